@@ -0,0 +1,118 @@
+package core
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker stops fetching a provider after threshold consecutive failed fetches. Once
+// cooldown has passed a single probe fetch is let through, its result closes or reopens it.
+type breaker struct {
+	provider  string
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	mu        sync.Mutex
+}
+
+// newBreaker reads executor.<name>.breaker.threshold and executor.<name>.breaker.cooldown,
+// a threshold of 0 disables the breaker.
+func newBreaker(name, provider string) (*breaker, error) {
+	key := "executor." + name + ".breaker"
+	b := &breaker{
+		provider:  provider,
+		threshold: 5,
+		cooldown:  5 * time.Minute,
+	}
+	if viper.IsSet(key + ".threshold") {
+		b.threshold = viper.GetInt(key + ".threshold")
+	}
+	if b.threshold < 0 {
+		return nil, fmt.Errorf("negative breaker threshold %d", b.threshold)
+	}
+	if viper.IsSet(key + ".cooldown") {
+		b.cooldown = viper.GetDuration(key + ".cooldown")
+	}
+	if b.cooldown < 0 {
+		return nil, fmt.Errorf("negative breaker cooldown %s", b.cooldown)
+	}
+	return b, nil
+}
+
+// allow reports whether a fetch may run now, an open breaker turns half-open after the cooldown.
+func (b *breaker) allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.setState(breakerHalfOpen)
+	}
+	return b.state != breakerOpen
+}
+
+// record counts the outcome of a fetch, canceled and empty fetches don't count either way.
+func (b *breaker) record(result *FetchResult) {
+	if b.threshold == 0 {
+		return
+	}
+	switch result.Category() {
+	case ErrCategoryCanceled, ErrCategoryEmpty:
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if result.Err == nil {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) setState(s breakerState) {
+	logrus.WithFields(logrus.Fields{
+		"provider": b.provider,
+		"from":     b.state.String(),
+		"to":       s.String(),
+		"failures": b.failures,
+	}).Warn("circuit breaker state changed")
+	b.state = s
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialThrough connects to addr through pxy using its dial type. The returned connection
+// is a tunnel to addr, the handshake is aborted once ctx is done.
+func dialThrough(ctx context.Context, pxy *Proxy, addr string) (net.Conn, error) {
+	d := &net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", pxy.Address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	switch pxy.DialType {
+	case public.DialTypeHttp:
+		err = connectHTTP(conn, pxy, addr)
+	case public.DialTypeHttps:
+		tc := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		conn = tc
+		if err = tc.HandshakeContext(ctx); err == nil {
+			err = connectHTTP(conn, pxy, addr)
+		}
+	case public.DialTypeSocks4, public.DialTypeSocks4a:
+		err = connectSocks4(ctx, conn, pxy, addr)
+	case public.DialTypeSocks5, public.DialTypeSocks5h:
+		err = connectSocks5(ctx, conn, pxy, addr)
+	default:
+		err = fmt.Errorf("unknown dial type %q", pxy.DialType)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func connectHTTP(conn net.Conn, pxy *Proxy, addr string) error {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if len(pxy.Username) != 0 || len(pxy.Password) != 0 {
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(pxy.Username+":"+pxy.Password)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+	res, err := http.ReadResponse(bufio.NewReaderSize(conn, 1), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused CONNECT with %s", res.Status)
+	}
+	return nil
+}
+
+// connectSocks4 sends a socks4 request, socks4a lets the proxy resolve host names.
+func connectSocks4(ctx context.Context, conn net.Conn, pxy *Proxy, addr string) error {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	req := []byte{4, 1, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+	var domain string
+	ip := net.ParseIP(host)
+	if ip == nil && pxy.DialType == public.DialTypeSocks4a {
+		domain = host
+		ip = net.IPv4(0, 0, 0, 1)
+	} else if ip == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+		if err != nil {
+			return err
+		}
+		ip = ips[0]
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("socks4 can't connect to %s", host)
+	}
+	req = append(req, ip4...)
+	req = append(req, pxy.Username...)
+	req = append(req, 0)
+	if len(domain) != 0 {
+		req = append(req, domain...)
+		req = append(req, 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	res := make([]byte, 8)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return err
+	}
+	if res[1] != 0x5a {
+		return fmt.Errorf("socks4 request rejected with code %d", res[1])
+	}
+	return nil
+}
+
+// connectSocks5 negotiates socks5, with username and password auth when pxy has credentials.
+// socks5 resolves host names locally while socks5h lets the proxy resolve them.
+func connectSocks5(ctx context.Context, conn net.Conn, pxy *Proxy, addr string) error {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	auth := len(pxy.Username) != 0 || len(pxy.Password) != 0
+	greeting := []byte{5, 1, 0}
+	if auth {
+		greeting = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("not a socks5 proxy, version %d", reply[0])
+	}
+	switch reply[1] {
+	case 0:
+	case 2:
+		if !auth {
+			return errors.New("socks5 proxy requires authentication")
+		}
+		if len(pxy.Username) > 255 || len(pxy.Password) > 255 {
+			return errors.New("socks5 credentials too long")
+		}
+		req := []byte{1, byte(len(pxy.Username))}
+		req = append(req, pxy.Username...)
+		req = append(req, byte(len(pxy.Password)))
+		req = append(req, pxy.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("socks5 authentication failed")
+		}
+	default:
+		return fmt.Errorf("socks5 proxy accepts no offered auth method, got %d", reply[1])
+	}
+
+	req := []byte{5, 1, 0}
+	ip := net.ParseIP(host)
+	if ip == nil && pxy.DialType == public.DialTypeSocks5 {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return err
+		}
+		ip = ips[0]
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, 1)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("socks5 connect failed with code %d", head[1])
+	}
+	var skip int
+	switch head[3] {
+	case 1:
+		skip = net.IPv4len
+	case 4:
+		skip = net.IPv6len
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("socks5 reply has unknown address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
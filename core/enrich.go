@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net"
+	"time"
+)
+
+const enrichRefreshBatch = 1000
+
+// enricher fills the columns derived from the address of a proxy: the geoip data and the
+// network type. Rows enriched by other databases or lists are refreshed.
+type enricher struct {
+	geo        *geoLocator
+	classifier *networkClassifier
+}
+
+// newEnricher returns nil when there's neither a geoip database nor a network list.
+func newEnricher() (*enricher, error) {
+	geo, err := newGeoLocator()
+	if err != nil {
+		return nil, err
+	}
+	classifier, err := newNetworkClassifier()
+	if err != nil {
+		return nil, err
+	}
+	if geo == nil && classifier == nil {
+		return nil, nil
+	}
+	return &enricher{geo: geo, classifier: classifier}, nil
+}
+
+func (e *enricher) version() string {
+	version := ""
+	if e.geo != nil {
+		version += e.geo.currentVersion()
+	}
+	if e.classifier != nil {
+		version += "networks@" + e.classifier.version
+	}
+	return version
+}
+
+func (e *enricher) enrich(pxy *Proxy) {
+	if e.geo != nil {
+		e.geo.enrich(pxy)
+	}
+	if e.classifier != nil {
+		var ip net.IP
+		if host, _, err := splitHostPort(pxy.Address); err == nil {
+			ip = net.ParseIP(host)
+		}
+		pxy.NetworkType = e.classifier.classify(ip, pxy.ASN)
+	}
+	pxy.EnrichVersion = e.version()
+}
+
+// run refreshes the stored rows at start and after every geoip database change, until ctx is done.
+func (e *enricher) run(ctx context.Context, db *gorm.DB) {
+	var changed chan struct{}
+	if e.geo != nil {
+		changed = e.geo.changed
+	}
+	for {
+		e.refresh(ctx, db)
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (e *enricher) refresh(ctx context.Context, db *gorm.DB) {
+	start := time.Now()
+	refreshed := 0
+	for ctx.Err() == nil {
+		proxies := make([]*Proxy, 0, enrichRefreshBatch)
+		if err := db.WithContext(ctx).Select("id", "address", "country", "asn").
+			Where("enrich_version is null or enrich_version <> ?", e.version()).
+			Limit(enrichRefreshBatch).
+			Find(&proxies).Error; err != nil {
+			if ctx.Err() == nil {
+				logrus.WithError(err).Error("failed to load proxies to enrich")
+			}
+			return
+		}
+		if len(proxies) == 0 {
+			break
+		}
+		for _, pxy := range proxies {
+			e.enrich(pxy)
+			if err := db.Model(&Proxy{}).Where("id = ?", pxy.Id).Updates(map[string]interface{}{
+				"country":        pxy.Country,
+				"city":           pxy.City,
+				"asn":            pxy.ASN,
+				"org":            pxy.Org,
+				"network_type":   pxy.NetworkType,
+				"enrich_version": pxy.EnrichVersion,
+			}).Error; err != nil {
+				logrus.WithError(err).WithField("address", pxy.Address).Error("failed to save enriched proxy")
+				return
+			}
+		}
+		refreshed += len(proxies)
+	}
+	if refreshed != 0 {
+		logrus.WithFields(logrus.Fields{
+			"refreshed": refreshed,
+			"duration":  time.Since(start).String(),
+		}).Info("stored proxies enriched")
+	}
+}
+
+func (e *enricher) close() {
+	if e.geo != nil {
+		e.geo.close()
+	}
+}
@@ -1,27 +1,88 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"github.com/JobberRT/pxier_fetcher/public"
-	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
 )
 
+// Executor fetches proxies from one kind of provider. Fetch should give up once ctx is done,
+// a failure is reported in FetchResult.Err rather than a second return value.
+// Executors holding resources like file watchers can implement io.Closer, they are closed
+// when the factory stops.
 type Executor interface {
-	Fetch() []*proxy
+	Fetch(ctx context.Context) FetchResult
 	Type() string
 }
 
-func NewExecutor(typ string) Executor {
-	switch typ {
-	case public.ExecutorTypeSTR:
-		return newSTRExecutor()
-	case public.ExecutorTypeCPL:
-		return newCPLExecutor()
-	case public.ExecutorTypeTSX:
-		return newTSXExecutor()
-	case public.ExecutorTypeIHuan:
-		return newIHuanExecutor()
-	default:
-		logrus.WithField("type", typ).Error("unknown executor type")
-		return nil
+// poolUser is implemented by executors able to fetch through proxies of the pool, the
+// factory hands them its pool when they are registered.
+type poolUser interface {
+	usePool(pool *proxyPool) error
+}
+
+// ExecutorConstructor creates an executor, it is called once for every selected executor name.
+type ExecutorConstructor func() Executor
+
+var (
+	executorTypes   = make(map[string]ExecutorConstructor)
+	executorTypesMu sync.RWMutex
+)
+
+func init() {
+	RegisterExecutorType(public.ExecutorTypeSTR, func() Executor { return newSTRExecutor() })
+	RegisterExecutorType(public.ExecutorTypeCPL, func() Executor { return newCPLExecutor() })
+	RegisterExecutorType(public.ExecutorTypeTSX, func() Executor { return newTSXExecutor() })
+	RegisterExecutorType(public.ExecutorTypeIHuan, func() Executor { return newIHuanExecutor() })
+	RegisterExecutorType(public.ExecutorTypeList, func() Executor { return newListExecutor() })
+	RegisterExecutorType(public.ExecutorTypeTable, func() Executor { return newTableExecutor() })
+	RegisterExecutorType(public.ExecutorTypeJSON, func() Executor { return newJSONExecutor() })
+	RegisterExecutorType(public.ExecutorTypeCommand, func() Executor { return newCommandExecutor() })
+	RegisterExecutorType(public.ExecutorTypeFile, func() Executor { return newFileExecutor() })
+}
+
+// RegisterExecutorType makes an executor selectable by name(case not sensitive) in factory.selected_executor.
+// It panics when the name is empty, the constructor is nil or the name is already registered.
+func RegisterExecutorType(name string, constructor ExecutorConstructor) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if len(name) == 0 {
+		panic("executor type name is empty")
+	}
+	if constructor == nil {
+		panic(fmt.Sprintf("executor type %s has nil constructor", name))
+	}
+	executorTypesMu.Lock()
+	defer executorTypesMu.Unlock()
+	if _, ok := executorTypes[name]; ok {
+		panic(fmt.Sprintf("executor type %s is already registered", name))
+	}
+	executorTypes[name] = constructor
+}
+
+// ExecutorTypes returns all registered executor type names.
+func ExecutorTypes() []string {
+	executorTypesMu.RLock()
+	defer executorTypesMu.RUnlock()
+	names := make([]string, 0, len(executorTypes))
+	for name := range executorTypes {
+		names = append(names, name)
+	}
+	return names
+}
+
+func NewExecutor(typ string) (Executor, error) {
+	typ = strings.ToUpper(strings.TrimSpace(typ))
+	executorTypesMu.RLock()
+	constructor, ok := executorTypes[typ]
+	executorTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown executor type %q", typ)
+	}
+	e := constructor()
+	if e == nil {
+		return nil, fmt.Errorf("executor type %q constructor returned nil", typ)
 	}
+	return e, nil
 }
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	commandStderrLimit = 64 * 1024
+	// commandWaitDelay is how long output pipes held open by escaped processes are waited for
+	// once the command is killed.
+	commandWaitDelay = 5 * time.Second
+)
+
+type commandSourceConfig struct {
+	Name      string          `mapstructure:"name"`
+	Command   string          `mapstructure:"command"`
+	Args      []string        `mapstructure:"args"`
+	Dir       string          `mapstructure:"dir"`
+	Env       []string        `mapstructure:"env"`
+	Format    string          `mapstructure:"format"`
+	DialType  public.DialType `mapstructure:"dial_type"`
+	Timeout   int64           `mapstructure:"timeout"`
+	MaxOutput int             `mapstructure:"max_output"`
+}
+
+type commandSource struct {
+	provider string
+	config   *commandSourceConfig
+	timeout  time.Duration
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest, writes
+// never fail so the command isn't killed by a broken pipe. Processes escaping a kill may
+// still write to it, so it's read through snapshot.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+	mu        sync.Mutex
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// snapshot returns a copy of what was kept and whether anything was discarded.
+func (b *limitedBuffer) snapshot() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...), b.truncated
+}
+
+type commandExecutor struct {
+	sources []*commandSource
+}
+
+func newCommandExecutor() *commandExecutor {
+	logrus.Info("creating command executor")
+	timeout := viper.GetInt64("executor.command.timeout")
+	if timeout == 0 {
+		timeout = 60
+	}
+	maxOutput := viper.GetInt("executor.command.max_output")
+	if maxOutput == 0 {
+		maxOutput = 32 * 1024 * 1024
+	}
+
+	configs := make([]*commandSourceConfig, 0)
+	if err := viper.UnmarshalKey("executor.command.sources", &configs); err != nil {
+		logrus.WithError(err).Panic("failed to parse command executor sources")
+	}
+	f := &commandExecutor{sources: make([]*commandSource, 0, len(configs))}
+	for _, c := range configs {
+		if len(c.Name) == 0 || len(c.Command) == 0 {
+			logrus.WithField("source", c).Error("command source needs a name and a command, skipped")
+			continue
+		}
+		if len(c.Format) == 0 {
+			c.Format = formatPlain
+		}
+		if !validFormat(c.Format) {
+			logrus.WithField("source", c).Error("command source format should be plain, csv or ndjson, skipped")
+			continue
+		}
+		if len(c.DialType) == 0 {
+			c.DialType = public.DialTypeHttp
+		}
+		if !c.DialType.Valid() {
+			logrus.WithField("source", c).Error("unknown command source dial type, skipped")
+			continue
+		}
+		if c.Timeout == 0 {
+			c.Timeout = timeout
+		}
+		if c.MaxOutput == 0 {
+			c.MaxOutput = maxOutput
+		}
+		f.sources = append(f.sources, &commandSource{
+			provider: strings.ToUpper(c.Name),
+			config:   c,
+			timeout:  time.Duration(c.Timeout) * time.Second,
+		})
+	}
+	if len(f.sources) == 0 {
+		logrus.Warn("command executor has no sources")
+	}
+	return f
+}
+
+func (f *commandExecutor) Fetch(ctx context.Context) FetchResult {
+	logrus.WithField("provider", f.Type()).Info("fetch")
+	sources := make([]sourceFunc, 0, len(f.sources))
+	for _, s := range f.sources {
+		sources = append(sources, s.fetch)
+	}
+	return runSources(ctx, sources)
+}
+
+func (f *commandExecutor) Type() string {
+	return public.ExecutorTypeCommand
+}
+
+func (s *commandSource) fetch(ctx context.Context) ([]*Proxy, SourceResult) {
+	logrus.WithFields(logrus.Fields{
+		"provider": s.provider,
+		"command":  s.config.Command,
+	}).Info("running proxy command")
+	sr := SourceResult{Name: s.provider, Url: s.config.Command}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: s.config.MaxOutput}
+	stderr := &limitedBuffer{limit: commandStderrLimit}
+	cmd := exec.Command(s.config.Command, s.config.Args...)
+	cmd.Dir = s.config.Dir
+	cmd.Env = append(os.Environ(), s.config.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	startProcessGroup(cmd)
+	err := s.run(ctx, cmd)
+	s.logStderr(stderr)
+	output, truncated := stdout.snapshot()
+	if err == nil && truncated {
+		err = fmt.Errorf("output exceeds %d bytes", s.config.MaxOutput)
+	}
+	if err != nil {
+		entry := logrus.WithError(err).WithFields(logrus.Fields{
+			"provider": s.provider,
+			"command":  s.config.Command,
+		})
+		exitErr := &exec.ExitError{}
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			entry.WithField("timeout", s.timeout).Error("proxy command timed out")
+		case errors.As(err, &exitErr):
+			entry.WithField("exit_code", exitErr.ExitCode()).Error("proxy command failed")
+		default:
+			entry.Error("failed to run proxy command")
+		}
+		if parent.Err() != nil {
+			sr.Err = newFetchError(ErrCategoryCanceled, s.config.Command, err)
+		} else {
+			sr.Err = newFetchError(ErrCategoryCommand, s.config.Command, err)
+		}
+		return nil, sr
+	}
+	sr.Bytes = len(output)
+
+	proxies, err := parseProxies(output, s.config.Format, s.provider, s.config.DialType)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"provider": s.provider,
+			"command":  s.config.Command,
+			"format":   s.config.Format,
+		}).Error("failed to parse proxy command output")
+		sr.Err = newFetchError(ErrCategoryParse, s.config.Command, err)
+		return nil, sr
+	}
+	return proxies, sr
+}
+
+// run starts cmd and waits for it. Once ctx is done the whole process group is killed, since
+// forked processes keep the output pipes open and Wait would block until they exit.
+func (s *commandSource) run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	if err := killProcessGroup(cmd); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"provider": s.provider,
+			"command":  s.config.Command,
+		}).Warn("failed to kill proxy command")
+	}
+	select {
+	case <-done:
+	case <-time.After(commandWaitDelay):
+		logrus.WithFields(logrus.Fields{
+			"provider": s.provider,
+			"command":  s.config.Command,
+		}).Warn("proxy command output is still open after kill, stop waiting")
+	}
+	return ctx.Err()
+}
+
+func (s *commandSource) logStderr(stderr *limitedBuffer) {
+	output, truncated := stderr.snapshot()
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"provider": s.provider,
+			"command":  s.config.Command,
+		}).Warn(scanner.Text())
+	}
+	if truncated {
+		logrus.WithFields(logrus.Fields{
+			"provider": s.provider,
+			"command":  s.config.Command,
+		}).Warn("proxy command stderr truncated")
+	}
+}
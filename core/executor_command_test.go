@@ -0,0 +1,87 @@
+//go:build !windows
+
+package core
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func newTestCommandSource(script string, timeout time.Duration, maxOutput int) *commandSource {
+	return &commandSource{
+		provider: "TEST",
+		config: &commandSourceConfig{
+			Name:      "test",
+			Command:   "sh",
+			Args:      []string{"-c", script},
+			Format:    formatPlain,
+			DialType:  public.DialTypeHttp,
+			MaxOutput: maxOutput,
+		},
+		timeout: timeout,
+	}
+}
+
+func TestCommandSourceFetch(t *testing.T) {
+	s := newTestCommandSource("echo 1.2.3.4:80; echo 5.6.7.8:8080; echo oops >&2", 5*time.Second, 1024)
+	proxies, sr := s.fetch(context.Background())
+	if sr.Err != nil {
+		t.Fatal(sr.Err)
+	}
+	if len(proxies) != 2 || proxies[0].Address != "1.2.3.4:80" || proxies[1].Address != "5.6.7.8:8080" {
+		t.Fatalf("unexpected proxies %v", proxies)
+	}
+}
+
+// Proxies printed before a non-zero exit aren't trusted, the fetch fails with the exit status.
+func TestCommandSourceExitStatus(t *testing.T) {
+	s := newTestCommandSource("echo 1.2.3.4:80; exit 3", 5*time.Second, 1024)
+	proxies, sr := s.fetch(context.Background())
+	if len(proxies) != 0 {
+		t.Fatalf("got proxies %v from a failed command", proxies)
+	}
+	if ErrorCategory(sr.Err) != ErrCategoryCommand {
+		t.Fatalf("got %v, want a command error", sr.Err)
+	}
+	exitErr := &exec.ExitError{}
+	if !errors.As(sr.Err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("got %v, want exit status 3", sr.Err)
+	}
+}
+
+// A forked process keeping stdout open must not make the fetch outlive its timeout.
+func TestCommandSourceKillsForkedProcesses(t *testing.T) {
+	s := newTestCommandSource("echo 1.2.3.4:80; sleep 30 & sleep 30", 500*time.Millisecond, 1024)
+	start := time.Now()
+	_, sr := s.fetch(context.Background())
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("fetch took %s", elapsed)
+	}
+	if ErrorCategory(sr.Err) != ErrCategoryCommand {
+		t.Fatalf("got %v, want a command error", sr.Err)
+	}
+}
+
+func TestCommandSourceCanceled(t *testing.T) {
+	s := newTestCommandSource("sleep 30 & sleep 30", time.Minute, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, sr := s.fetch(ctx)
+	if ErrorCategory(sr.Err) != ErrCategoryCanceled {
+		t.Fatalf("got %v, want a canceled error", sr.Err)
+	}
+}
+
+func TestCommandSourceMaxOutput(t *testing.T) {
+	s := newTestCommandSource("yes 1.2.3.4:80 | head -n 1000", 5*time.Second, 100)
+	_, sr := s.fetch(context.Background())
+	if sr.Err == nil || !strings.Contains(sr.Err.Error(), "exceeds 100 bytes") {
+		t.Fatalf("got %v, want an output size error", sr.Err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package core
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup puts the command in its own process group, so the processes it forks
+// can be killed with it.
+func startProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
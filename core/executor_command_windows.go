@@ -0,0 +1,12 @@
+//go:build windows
+
+package core
+
+import "os/exec"
+
+// startProcessGroup does nothing on windows, only the command itself is killed.
+func startProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
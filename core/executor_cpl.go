@@ -1,20 +1,14 @@
 package core
 
 import (
-	"crypto/tls"
+	"context"
 	"github.com/JobberRT/pxier_fetcher/public"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpproxy"
-	"strings"
-	"time"
 )
 
 type cplExecutor struct {
-	url     string
-	timeout time.Duration
-	client  *fasthttp.Client
+	source *listSource
 }
 
 func newCPLExecutor() *cplExecutor {
@@ -27,65 +21,28 @@ func newCPLExecutor() *cplExecutor {
 	if timeout == 0 {
 		timeout = 5
 	}
-	f := &cplExecutor{
-		url:     url,
-		timeout: time.Duration(timeout) * time.Second,
-		client:  &fasthttp.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+	urls := []listURL{
+		{Url: url, DialType: public.DialTypeHttp},
 	}
-	proxy := viper.GetString("executor.cpl.proxy")
-	if len(proxy) != 0 {
-		if strings.Contains(proxy, "http") {
-			f.client.Dial = fasthttpproxy.FasthttpHTTPDialer(proxy)
-		} else {
-			f.client.Dial = fasthttpproxy.FasthttpSocksDialer(proxy)
-		}
+	source, err := newListSource(public.ExecutorTypeCPL, urls, upstreamURLs("executor.cpl"), timeout, newRetryPolicy("executor.cpl"))
+	if err != nil {
+		logrus.WithError(err).Panic("bad cpl executor proxy")
 	}
-	return f
+	return &cplExecutor{source: source}
 }
 
-func (f *cplExecutor) Fetch() []*proxy {
+func (f *cplExecutor) Fetch(ctx context.Context) FetchResult {
 	logrus.WithField("provider", f.Type()).Info("fetching proxy")
-	req := fasthttp.AcquireRequest()
-	res := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(res)
+	return f.source.fetch(ctx)
+}
 
-	req.SetRequestURI(f.url)
-	req.Header.SetMethod(fasthttp.MethodGet)
-	req.Header.SetContentEncoding("gzip")
-	if err := f.client.DoTimeout(req, res, f.timeout); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"url":      f.url,
-			"provider": f.Type(),
-		}).Error("failed to fetch proxy")
-		return nil
-	}
+func (f *cplExecutor) usePool(pool *proxyPool) error {
+	return f.source.http.usePool("executor.cpl", pool)
+}
 
-	body, err := readBody(res)
-	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"raw":      string(body),
-			"url":      f.url,
-			"provider": f.Type(),
-		}).Error("failed to unGzip body")
-		return nil
-	}
-	rawSlice := strings.Split(string(body), "\n")
-	proxies := make([]*proxy, 0)
-	for _, each := range rawSlice {
-		if len(each) == 0 {
-			continue
-		}
-		proxies = append(proxies, &proxy{
-			Address:   each,
-			ErrTimes:  0,
-			CreatedAt: time.Now().Unix(),
-			UpdatedAt: time.Now().Unix(),
-			Provider:  public.ExecutorTypeCPL,
-			DialType:  public.DialTypeHttp,
-		})
-	}
-	return proxies
+func (f *cplExecutor) Close() error {
+	f.source.http.close()
+	return nil
 }
 
 func (f *cplExecutor) Type() string {
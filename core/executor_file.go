@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fileSourceConfig struct {
+	Name     string          `mapstructure:"name"`
+	Paths    []string        `mapstructure:"paths"`
+	Format   string          `mapstructure:"format"`
+	DialType public.DialType `mapstructure:"dial_type"`
+}
+
+type fileSource struct {
+	provider string
+	config   *fileSourceConfig
+	mu       sync.RWMutex
+	proxies  []*Proxy
+	size     int
+	watcher  *fileWatcher
+}
+
+type fileExecutor struct {
+	sources []*fileSource
+}
+
+func newFileExecutor() *fileExecutor {
+	logrus.Info("creating file executor")
+	configs := make([]*fileSourceConfig, 0)
+	if err := viper.UnmarshalKey("executor.file.sources", &configs); err != nil {
+		logrus.WithError(err).Panic("failed to parse file executor sources")
+	}
+	f := &fileExecutor{sources: make([]*fileSource, 0, len(configs))}
+	for _, c := range configs {
+		if len(c.Name) == 0 || len(c.Paths) == 0 {
+			logrus.WithField("source", c).Error("file source needs a name and at least one path, skipped")
+			continue
+		}
+		if len(c.Format) != 0 && !validFormat(c.Format) {
+			logrus.WithField("source", c).Error("file source format should be plain, csv or ndjson, skipped")
+			continue
+		}
+		if len(c.DialType) == 0 {
+			c.DialType = public.DialTypeHttp
+		}
+		if !c.DialType.Valid() {
+			logrus.WithField("source", c).Error("unknown file source dial type, skipped")
+			continue
+		}
+		s := &fileSource{
+			provider: strings.ToUpper(c.Name),
+			config:   c,
+		}
+		s.load()
+		s.watcher = watchFiles(s.provider+" files", c.Paths, s.load)
+		f.sources = append(f.sources, s)
+	}
+	if len(f.sources) == 0 {
+		logrus.Warn("file executor has no sources")
+	}
+	return f
+}
+
+func (f *fileExecutor) Fetch(ctx context.Context) FetchResult {
+	logrus.WithField("provider", f.Type()).Info("fetch")
+	sources := make([]sourceFunc, 0, len(f.sources))
+	for _, s := range f.sources {
+		sources = append(sources, s.fetch)
+	}
+	return runSources(ctx, sources)
+}
+
+func (f *fileExecutor) Close() error {
+	for _, s := range f.sources {
+		if s.watcher != nil {
+			s.watcher.close()
+		}
+	}
+	return nil
+}
+
+func (f *fileExecutor) Type() string {
+	return public.ExecutorTypeFile
+}
+
+// fetch returns copies of the cached proxies, gorm writes ids back into saved rows.
+func (s *fileSource) fetch(ctx context.Context) ([]*Proxy, SourceResult) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sr := SourceResult{Name: s.provider, Url: strings.Join(s.config.Paths, ","), Bytes: s.size}
+	proxies := make([]*Proxy, 0, len(s.proxies))
+	for _, p := range s.proxies {
+		cp := *p
+		cp.CreatedAt = time.Now().Unix()
+		cp.UpdatedAt = time.Now().Unix()
+		proxies = append(proxies, &cp)
+	}
+	return proxies, sr
+}
+
+func (s *fileSource) load() {
+	proxies := make([]*Proxy, 0)
+	size := 0
+	for _, pattern := range s.config.Paths {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"provider": s.provider,
+				"pattern":  pattern,
+			}).Error("bad file pattern")
+			continue
+		}
+		for _, file := range files {
+			loaded, n := s.loadFile(file)
+			proxies = append(proxies, loaded...)
+			size += n
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"provider": s.provider,
+		"count":    len(proxies),
+	}).Info("proxy files loaded")
+	s.mu.Lock()
+	s.proxies = proxies
+	s.size = size
+	s.mu.Unlock()
+}
+
+func (s *fileSource) loadFile(file string) ([]*Proxy, int) {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"provider": s.provider,
+			"file":     file,
+		}).Error("failed to read proxy file")
+		return nil, 0
+	}
+	body, err = maybeGunzip(body)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"provider": s.provider,
+			"file":     file,
+		}).Error("failed to unGzip proxy file")
+		return nil, 0
+	}
+	format := s.config.Format
+	if len(format) == 0 {
+		format = formatOfFile(file)
+	}
+	proxies, err := parseProxies(body, format, s.provider, s.config.DialType)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"provider": s.provider,
+			"file":     file,
+			"format":   format,
+		}).Error("failed to parse proxy file")
+		return nil, 0
+	}
+	return proxies, len(body)
+}
+
+func formatOfFile(file string) string {
+	switch filepath.Ext(strings.TrimSuffix(file, ".gz")) {
+	case ".csv":
+		return formatCSV
+	case ".ndjson", ".jsonl":
+		return formatNDJSON
+	default:
+		return formatPlain
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Files matching a source's glob are picked up once they're created, the others are ignored.
+func TestFileExecutorReload(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("1.2.3.4:80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("executor.file.sources", []map[string]interface{}{
+		{"name": "test", "paths": []string{filepath.Join(dir, "*.txt")}},
+	})
+	t.Cleanup(viper.Reset)
+	f := newFileExecutor()
+	defer f.Close()
+	if len(f.sources) != 1 {
+		t.Fatalf("got %d sources", len(f.sources))
+	}
+	s := f.sources[0]
+	if proxies, _ := s.fetch(context.Background()); len(proxies) != 1 {
+		t.Fatalf("got %d proxies, want 1", len(proxies))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "c.csv"), []byte("9.9.9.9:80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.tmp"), []byte("5.6.7.8:8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "b.tmp"), filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		proxies, _ := s.fetch(context.Background())
+		if len(proxies) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d proxies after the new file, want 2", len(proxies))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -1,21 +1,27 @@
 package core
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/JobberRT/pxier_fetcher/public"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpproxy"
 	"regexp"
-	"strings"
 	"time"
 )
 
 var (
 	keyPattern = regexp.MustCompile("[a-z\\d]{32}")
-	ipPattern  = regexp.MustCompile("\\d{1,3}\\.\\d{1,3}\\.\\d{1,3}\\.\\d{1,3}:\\d{1,5}")
+	// ipPattern matches ipv4:port and [ipv6]:port
+	ipPattern = regexp.MustCompile("(?:\\b(?:(?:25[0-5]|2[0-4]\\d|1\\d\\d|[1-9]?\\d)\\.){3}(?:25[0-5]|2[0-4]\\d|1\\d\\d|[1-9]?\\d)|\\[[\\da-fA-F:.]{2,45}\\]):\\d{1,5}\\b")
+	// ihuanAnonymity maps anonymity levels to the codes of ihuan's anonymity filter
+	ihuanAnonymity = map[string]string{
+		public.AnonymityTransparent: "0",
+		public.AnonymityAnonymous:   "1",
+		public.AnonymityElite:       "2",
+	}
 )
 
 type ihuanExecutor struct {
@@ -24,10 +30,10 @@ type ihuanExecutor struct {
 	keyUrl        string
 	key           string
 	zone          string
+	anonymity     string
 	statistics    string
 	eachFetchNum  int
-	timeout       time.Duration
-	client        *fasthttp.Client
+	http          *httpFetcher
 }
 
 func newIHuanExecutor() *ihuanExecutor {
@@ -53,40 +59,45 @@ func newIHuanExecutor() *ihuanExecutor {
 		efn = 100
 	}
 	zone := viper.GetString("executor.ihuan.zone")
+	anonymity := normalizeAnonymity(viper.GetString("executor.ihuan.anonymity"))
+	if _, ok := ihuanAnonymity[anonymity]; len(anonymity) != 0 && !ok {
+		logrus.WithField("anonymity", anonymity).Panic("unknown ihuan anonymity")
+	}
+	h, err := newHttpFetcher(upstreamURLs("executor.ihuan"), time.Duration(timeout)*time.Second, newRetryPolicy("executor.ihuan"))
+	if err != nil {
+		logrus.WithError(err).Panic("bad ihuan executor proxy")
+	}
 	f := &ihuanExecutor{
 		httpUrl:       hu,
 		statisticsUrl: su,
 		keyUrl:        ku,
 		eachFetchNum:  efn,
 		zone:          zone,
-		timeout:       time.Duration(timeout) * time.Second,
-		client:        &fasthttp.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
-	}
-	proxy := viper.GetString("executor.ihuan.proxy")
-	if len(proxy) != 0 {
-		if strings.Contains(proxy, "http") {
-			f.client.Dial = fasthttpproxy.FasthttpHTTPDialer(proxy)
-		} else {
-			f.client.Dial = fasthttpproxy.FasthttpSocksDialer(proxy)
-		}
+		anonymity:     anonymity,
+		http:          h,
 	}
 	return f
 }
 
-func (f *ihuanExecutor) Fetch() []*proxy {
+func (f *ihuanExecutor) Fetch(ctx context.Context) FetchResult {
+	return runSources(ctx, []sourceFunc{f.fetch})
+}
+
+func (f *ihuanExecutor) fetch(ctx context.Context) ([]*Proxy, SourceResult) {
 	logrus.WithField("provider", f.Type()).Info("fetching proxy")
+	sr := SourceResult{Name: f.Type(), Url: f.httpUrl}
 	if len(f.statistics) == 0 {
-		f.generateStatistics()
+		f.generateStatistics(ctx)
 	}
 	if len(f.key) == 0 {
-		f.generateKey()
+		f.generateKey(ctx)
 	}
 	req := fasthttp.AcquireRequest()
 	res := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(res)
 
-	postData := fmt.Sprintf("num=%d&port=&kill_port=&address=%s&kill_address=&anonymity=&type=&post=&sort=1&key=%s", f.eachFetchNum, f.zone, f.key)
+	postData := fmt.Sprintf("num=%d&port=&kill_port=&address=%s&kill_address=&anonymity=%s&type=&post=&sort=1&key=%s", f.eachFetchNum, f.zone, ihuanAnonymity[f.anonymity], f.key)
 	req.SetRequestURI(f.httpUrl)
 	req.SetBodyString(postData)
 	req.Header.SetMethod(fasthttp.MethodPost)
@@ -94,13 +105,29 @@ func (f *ihuanExecutor) Fetch() []*proxy {
 	req.Header.SetContentType("application/x-www-form-urlencoded")
 	req.Header.SetUserAgent("Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/45.0.2454.85 Safari/537.36")
 	req.Header.SetReferer("https://ip.ihuan.me/ti.html")
-	if err := f.client.DoTimeout(req, res, f.timeout); err != nil {
+	if err := f.http.do(ctx, req, res); err != nil {
 		logrus.WithError(err).WithFields(logrus.Fields{
 			"url":      f.httpUrl,
 			"provider": f.Type(),
 		}).Error("failed to fetch proxy")
-		return nil
+		sr.Err = requestError(ctx, f.httpUrl, err)
+		return nil, sr
+	}
+	if res.StatusCode() != fasthttp.StatusOK {
+		logrus.WithFields(logrus.Fields{
+			"url":      f.httpUrl,
+			"provider": f.Type(),
+			"status":   res.StatusCode(),
+		}).Error("failed to fetch proxy")
+		sr.Err = &FetchError{
+			Category:   ErrCategoryStatus,
+			Url:        f.httpUrl,
+			StatusCode: res.StatusCode(),
+			Err:        fmt.Errorf("unexpected status code %d", res.StatusCode()),
+		}
+		return nil, sr
 	}
+	sr.Bytes = len(res.Body())
 
 	body, err := readBody(res)
 	if err != nil {
@@ -109,7 +136,8 @@ func (f *ihuanExecutor) Fetch() []*proxy {
 			"url":      f.httpUrl,
 			"provider": f.Type(),
 		}).Error("failed to unGzip body")
-		return nil
+		sr.Err = newFetchError(ErrCategoryDecode, f.httpUrl, err)
+		return nil, sr
 	}
 	Ips := ipPattern.FindAll(body, -1)
 	if Ips == nil {
@@ -118,31 +146,36 @@ func (f *ihuanExecutor) Fetch() []*proxy {
 			"url":      f.httpUrl,
 			"provider": f.Type(),
 		}).Error("empty ips")
-		return nil
+		sr.Err = newFetchError(ErrCategoryEmpty, f.httpUrl, errors.New("no proxy in response"))
+		return nil, sr
 	}
 
-	proxies := make([]*proxy, 0)
+	proxies := make([]*Proxy, 0)
 	for _, ip := range Ips {
 		if ip == nil {
 			continue
 		}
-		proxies = append(proxies, &proxy{
-			Address:   string(ip),
-			Provider:  public.ExecutorTypeIHuan,
-			CreatedAt: time.Now().Unix(),
-			UpdatedAt: time.Now().Unix(),
-			ErrTimes:  0,
-			DialType:  public.DialTypeHttp,
-		})
-	}
-	return proxies
+		pxy := newProxy(string(ip), public.ExecutorTypeIHuan, public.DialTypeHttp)
+		pxy.Anonymity = f.anonymity
+		proxies = append(proxies, pxy)
+	}
+	return proxies, sr
+}
+
+func (f *ihuanExecutor) usePool(pool *proxyPool) error {
+	return f.http.usePool("executor.ihuan", pool)
+}
+
+func (f *ihuanExecutor) Close() error {
+	f.http.close()
+	return nil
 }
 
 func (f *ihuanExecutor) Type() string {
 	return public.ExecutorTypeIHuan
 }
 
-func (f *ihuanExecutor) generateStatistics() {
+func (f *ihuanExecutor) generateStatistics(ctx context.Context) {
 	logrus.WithField("provider", f.Type()).Info("generate statistics")
 	req := fasthttp.AcquireRequest()
 	res := fasthttp.AcquireResponse()
@@ -153,7 +186,7 @@ func (f *ihuanExecutor) generateStatistics() {
 	req.Header.SetMethod(fasthttp.MethodGet)
 	req.Header.SetUserAgent("Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/45.0.2454.85 Safari/537.36")
 	req.Header.Set("Accept-Encoding", "br")
-	if err := f.client.DoTimeout(req, res, f.timeout); err != nil {
+	if err := f.http.do(ctx, req, res); err != nil {
 		logrus.WithError(err).WithField("url", f.statisticsUrl).Error("failed to get statistics")
 		return
 	}
@@ -164,7 +197,7 @@ func (f *ihuanExecutor) generateStatistics() {
 	f.statistics = string(res.Header.Peek("Set-Cookie"))
 }
 
-func (f *ihuanExecutor) generateKey() {
+func (f *ihuanExecutor) generateKey(ctx context.Context) {
 	logrus.WithField("provider", f.Type()).Info("generate key")
 	req := fasthttp.AcquireRequest()
 	res := fasthttp.AcquireResponse()
@@ -177,7 +210,7 @@ func (f *ihuanExecutor) generateKey() {
 	req.Header.Set("Accept-Encoding", "br")
 	req.Header.SetReferer(f.statisticsUrl)
 	req.Header.Set("Cookie", f.statistics)
-	if err := f.client.DoTimeout(req, res, f.timeout); err != nil {
+	if err := f.http.do(ctx, req, res); err != nil {
 		logrus.WithError(err).WithField("url", f.statisticsUrl).Error("failed to get statistics")
 		return
 	}
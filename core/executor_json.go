@@ -0,0 +1,317 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	paginationNone   = ""
+	paginationPage   = "page"
+	paginationCursor = "cursor"
+	paginationNext   = "next"
+)
+
+type jsonFields struct {
+	Address   string `mapstructure:"address"`
+	Ip        string `mapstructure:"ip"`
+	Port      string `mapstructure:"port"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
+	Protocol  string `mapstructure:"protocol"`
+	Country   string `mapstructure:"country"`
+	Anonymity string `mapstructure:"anonymity"`
+}
+
+type jsonPagination struct {
+	Type       string `mapstructure:"type"`
+	StartPage  int    `mapstructure:"start_page"`
+	CursorPath string `mapstructure:"cursor_path"`
+	NextPath   string `mapstructure:"next_path"`
+	MaxPages   int    `mapstructure:"max_pages"`
+}
+
+type jsonSourceConfig struct {
+	Name        string                     `mapstructure:"name"`
+	Url         string                     `mapstructure:"url"`
+	Timeout     int64                      `mapstructure:"timeout"`
+	Proxy       string                     `mapstructure:"proxy"`
+	Proxies     []string                   `mapstructure:"proxies"`
+	ListPath    string                     `mapstructure:"list_path"`
+	Fields      jsonFields                 `mapstructure:"fields"`
+	DialType    public.DialType            `mapstructure:"dial_type"`
+	ProtocolMap map[string]public.DialType `mapstructure:"protocol_map"`
+	Pagination  jsonPagination             `mapstructure:"pagination"`
+}
+
+type jsonSource struct {
+	provider string
+	config   *jsonSourceConfig
+	http     *httpFetcher
+}
+
+type jsonExecutor struct {
+	sources []*jsonSource
+}
+
+func newJSONExecutor() *jsonExecutor {
+	logrus.Info("creating json executor")
+	timeout := viper.GetInt64("executor.json.timeout")
+	if timeout == 0 {
+		timeout = 15
+	}
+	proxies := upstreamURLs("executor.json")
+	retry := newRetryPolicy("executor.json")
+
+	configs := make([]*jsonSourceConfig, 0)
+	if err := viper.UnmarshalKey("executor.json.sources", &configs); err != nil {
+		logrus.WithError(err).Panic("failed to parse json executor sources")
+	}
+	f := &jsonExecutor{sources: make([]*jsonSource, 0, len(configs))}
+	for _, c := range configs {
+		if len(c.Name) == 0 || len(c.Url) == 0 || (len(c.Fields.Address) == 0 && len(c.Fields.Ip) == 0) {
+			logrus.WithField("source", c).Error("json source needs a name, an url and an address or ip field, skipped")
+			continue
+		}
+		switch c.Pagination.Type {
+		case paginationNone, paginationPage, paginationCursor, paginationNext:
+		default:
+			logrus.WithField("source", c).Error("unknown json source pagination type, skipped")
+			continue
+		}
+		if c.Pagination.MaxPages == 0 {
+			c.Pagination.MaxPages = 10
+		}
+		if len(c.DialType) == 0 {
+			c.DialType = public.DialTypeHttp
+		}
+		if err := validDialTypes(c.DialType, c.ProtocolMap); err != nil {
+			logrus.WithError(err).WithField("source", c).Error("bad json source dial type, skipped")
+			continue
+		}
+		if c.Timeout == 0 {
+			c.Timeout = timeout
+		}
+		h, err := newHttpFetcher(sourceUpstreams(c.Proxies, c.Proxy, proxies), time.Duration(c.Timeout)*time.Second, retry)
+		if err != nil {
+			logrus.WithError(err).WithField("source", c.Name).Error("bad json source proxy, skipped")
+			continue
+		}
+		f.sources = append(f.sources, &jsonSource{
+			provider: strings.ToUpper(c.Name),
+			config:   c,
+			http:     h,
+		})
+	}
+	if len(f.sources) == 0 {
+		logrus.Warn("json executor has no sources")
+	}
+	return f
+}
+
+func (f *jsonExecutor) Fetch(ctx context.Context) FetchResult {
+	logrus.WithField("provider", f.Type()).Info("fetch")
+	sources := make([]sourceFunc, 0, len(f.sources))
+	for _, s := range f.sources {
+		sources = append(sources, s.fetch)
+	}
+	return runSources(ctx, sources)
+}
+
+func (f *jsonExecutor) usePool(pool *proxyPool) error {
+	for _, s := range f.sources {
+		if err := s.http.usePool("executor.json", pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *jsonExecutor) Close() error {
+	for _, s := range f.sources {
+		s.http.close()
+	}
+	return nil
+}
+
+func (f *jsonExecutor) Type() string {
+	return public.ExecutorTypeJSON
+}
+
+func (s *jsonSource) fetch(ctx context.Context) ([]*Proxy, SourceResult) {
+	logrus.WithField("provider", s.provider).Info("fetching json proxy api")
+	sr := SourceResult{Name: s.provider, Url: s.config.Url}
+	pg := s.config.Pagination
+	proxies := make([]*Proxy, 0)
+	page := pg.StartPage
+	cursor := ""
+	next := ""
+	// a failed page ends the pagination, proxies of previous pages are kept
+	fail := func(err error) ([]*Proxy, SourceResult) {
+		if len(proxies) == 0 {
+			sr.Err = err
+		}
+		return proxies, sr
+	}
+	for i := 0; i < pg.MaxPages; i++ {
+		u := s.pageUrl(page, cursor, next)
+		res, err := s.http.get(ctx, u, false)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"url":      u,
+				"provider": s.provider,
+			}).Error("failed to fetch json proxy api")
+			return fail(err)
+		}
+		sr.Bytes += res.size
+		var doc interface{}
+		if err := json.Unmarshal(res.body, &doc); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"raw":      string(res.body),
+				"url":      u,
+				"provider": s.provider,
+			}).Error("failed to decode json body")
+			return fail(newFetchError(ErrCategoryDecode, u, err))
+		}
+		items, ok := jsonPath(doc, s.config.ListPath).([]interface{})
+		if !ok {
+			logrus.WithFields(logrus.Fields{
+				"url":       u,
+				"provider":  s.provider,
+				"list_path": s.config.ListPath,
+			}).Error("json list path is not an array")
+			return fail(newFetchError(ErrCategoryParse, u, fmt.Errorf("json list path %q is not an array", s.config.ListPath)))
+		}
+		proxies = append(proxies, s.parseItems(items)...)
+
+		switch pg.Type {
+		case paginationPage:
+			if len(items) == 0 {
+				return proxies, sr
+			}
+			page++
+		case paginationCursor:
+			cursor = jsonField(doc, pg.CursorPath)
+			if len(cursor) == 0 {
+				return proxies, sr
+			}
+		case paginationNext:
+			next = jsonField(doc, pg.NextPath)
+			if len(next) == 0 {
+				return proxies, sr
+			}
+			if n, err := url.Parse(next); err == nil {
+				if base, err := url.Parse(u); err == nil {
+					next = base.ResolveReference(n).String()
+				}
+			}
+		default:
+			return proxies, sr
+		}
+	}
+	return proxies, sr
+}
+
+func (s *jsonSource) pageUrl(page int, cursor, next string) string {
+	if len(next) != 0 {
+		return next
+	}
+	return strings.NewReplacer(
+		"{page}", strconv.Itoa(page),
+		"{cursor}", url.QueryEscape(cursor),
+	).Replace(s.config.Url)
+}
+
+func (s *jsonSource) parseItems(items []interface{}) []*Proxy {
+	fields := s.config.Fields
+	proxies := make([]*Proxy, 0, len(items))
+	for _, item := range items {
+		address := jsonField(item, fields.Address)
+		// an empty ip doesn't override the address
+		if ip := jsonField(item, fields.Ip); len(ip) != 0 {
+			address = ip
+			if port := jsonField(item, fields.Port); len(port) != 0 {
+				address = joinHostPort(address, port)
+			}
+		}
+		if len(address) == 0 {
+			continue
+		}
+		p := newProxy(address, s.provider, s.dialType(item))
+		if username := jsonField(item, fields.Username); len(username) != 0 {
+			p.Username = username
+			p.Password = jsonField(item, fields.Password)
+		}
+		p.Country = jsonField(item, fields.Country)
+		p.Anonymity = normalizeAnonymity(jsonField(item, fields.Anonymity))
+		proxies = append(proxies, p)
+	}
+	return proxies
+}
+
+func (s *jsonSource) dialType(item interface{}) public.DialType {
+	if len(s.config.Fields.Protocol) == 0 {
+		return s.config.DialType
+	}
+	v := jsonPath(item, s.config.Fields.Protocol)
+	// some apis give a list of supported protocols, the first one wins
+	if list, ok := v.([]interface{}); ok {
+		if len(list) == 0 {
+			return s.config.DialType
+		}
+		v = list[0]
+	}
+	return mapDialType(jsonString(v), s.config.ProtocolMap, s.config.DialType)
+}
+
+// jsonPath walks a dot separated path like "data.list.0.ip", an empty path returns v itself.
+func jsonPath(v interface{}, path string) interface{} {
+	if len(path) == 0 {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func jsonField(item interface{}, path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return jsonString(jsonPath(item, path))
+}
+
+func jsonString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
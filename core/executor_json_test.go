@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func newTestJSONSource(t *testing.T, c *jsonSourceConfig) *jsonSource {
+	t.Helper()
+	h, err := newHttpFetcher(nil, 5*time.Second, retryPolicy{attempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.close)
+	if len(c.DialType) == 0 {
+		c.DialType = public.DialTypeHttp
+	}
+	if c.Pagination.MaxPages == 0 {
+		c.Pagination.MaxPages = 10
+	}
+	return &jsonSource{provider: "TEST", config: c, http: h}
+}
+
+func proxyAddresses(proxies []*Proxy) string {
+	list := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		list = append(list, p.Address)
+	}
+	return strings.Join(list, " ")
+}
+
+func TestJSONPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"data": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{"ip": "1.2.3.4", "port": float64(80)},
+			},
+			"total": float64(1),
+		},
+	}
+	cases := map[string]string{
+		"data.list.0.ip":   "1.2.3.4",
+		"data.list.0.port": "80",
+		"data.total":       "1",
+		"data.list.1.ip":   "",
+		"data.list.-1.ip":  "",
+		"data.list.x.ip":   "",
+		"data.total.x":     "",
+		"missing.path":     "",
+	}
+	for path, want := range cases {
+		if got := jsonField(doc, path); got != want {
+			t.Errorf("jsonField(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if jsonPath(doc, "") == nil {
+		t.Error("an empty path should return the document")
+	}
+}
+
+func TestJSONParseItems(t *testing.T) {
+	s := newTestJSONSource(t, &jsonSourceConfig{
+		Fields: jsonFields{
+			Address:   "address",
+			Ip:        "conn.ip",
+			Port:      "conn.port",
+			Username:  "auth.user",
+			Password:  "auth.pass",
+			Protocol:  "protocols",
+			Country:   "geo.country",
+			Anonymity: "level",
+		},
+		ProtocolMap: map[string]public.DialType{"s5": public.DialTypeSocks5h},
+	})
+	body := `[
+		{"conn": {"ip": "1.2.3.4", "port": 8080}, "protocols": ["socks5", "http"], "geo": {"country": "US"}, "level": "elite"},
+		{"conn": {"ip": "2001:4860::8888", "port": "443"}, "protocols": "https"},
+		{"conn": {"ip": ""}, "address": "5.6.7.8:3128", "protocols": []},
+		{"address": "9.9.9.9:1080", "protocols": "s5", "auth": {"user": "user", "pass": 123456}},
+		{"conn": {"ip": "", "port": 80}},
+		{"geo": {"country": "DE"}}
+	]`
+	var items []interface{}
+	if err := json.Unmarshal([]byte(body), &items); err != nil {
+		t.Fatal(err)
+	}
+	proxies := s.parseItems(items)
+	want := []Proxy{
+		{Address: "1.2.3.4:8080", DialType: public.DialTypeSocks5, Country: "US", Anonymity: public.AnonymityElite},
+		{Address: "[2001:4860::8888]:443", DialType: public.DialTypeHttp},
+		{Address: "5.6.7.8:3128", DialType: public.DialTypeHttp},
+		{Address: "9.9.9.9:1080", DialType: public.DialTypeSocks5h, Username: "user", Password: "123456"},
+	}
+	if len(proxies) != len(want) {
+		t.Fatalf("got %s, want %d proxies", proxyAddresses(proxies), len(want))
+	}
+	for i, w := range want {
+		p := proxies[i]
+		if p.Address != w.Address || p.DialType != w.DialType || p.Country != w.Country || p.Anonymity != w.Anonymity ||
+			p.Username != w.Username || p.Password != w.Password || p.Provider != "TEST" {
+			t.Errorf("proxy %d: got %+v, want %+v", i, *p, w)
+		}
+	}
+}
+
+func TestJSONPagination(t *testing.T) {
+	// every page holds one proxy, 1.0.0.<page>
+	pages := 3
+	requested := make([]string, 0)
+	mu := sync.Mutex{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		page := 0
+		switch {
+		case len(r.URL.Query().Get("page")) != 0:
+			fmt.Sscan(r.URL.Query().Get("page"), &page)
+		case len(r.URL.Query().Get("cursor")) != 0:
+			fmt.Sscanf(r.URL.Query().Get("cursor"), "c%d", &page)
+		case strings.HasPrefix(r.URL.Path, "/next/"):
+			fmt.Sscan(strings.TrimPrefix(r.URL.Path, "/next/"), &page)
+		default:
+			page = 1
+		}
+		if r.URL.Path == "/broken" && page == 2 {
+			w.Write([]byte("{"))
+			return
+		}
+		if page > pages {
+			fmt.Fprint(w, `{"data": [], "cursor": "", "next": ""}`)
+			return
+		}
+		cursor, next := fmt.Sprintf("c%d", page+1), fmt.Sprintf("/next/%d", page+1)
+		if page == pages {
+			cursor, next = "", ""
+		}
+		fmt.Fprintf(w, `{"data": [{"ip": "1.0.0.%d", "port": 80}], "cursor": %q, "next": %q}`, page, cursor, next)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name       string
+		url        string
+		pagination jsonPagination
+		want       string
+		requests   int
+		fails      bool
+	}{
+		{"none", srv.URL + "/", jsonPagination{}, "1.0.0.1:80", 1, false},
+		{"page", srv.URL + "/?page={page}", jsonPagination{Type: paginationPage, StartPage: 1}, "1.0.0.1:80 1.0.0.2:80 1.0.0.3:80", 4, false},
+		{"page capped", srv.URL + "/?page={page}", jsonPagination{Type: paginationPage, StartPage: 2, MaxPages: 1}, "1.0.0.2:80", 1, false},
+		{"cursor", srv.URL + "/?cursor={cursor}", jsonPagination{Type: paginationCursor, CursorPath: "cursor"}, "1.0.0.1:80 1.0.0.2:80 1.0.0.3:80", 3, false},
+		{"next", srv.URL + "/", jsonPagination{Type: paginationNext, NextPath: "next"}, "1.0.0.1:80 1.0.0.2:80 1.0.0.3:80", 3, false},
+		{"failed page keeps earlier ones", srv.URL + "/broken?page={page}", jsonPagination{Type: paginationPage, StartPage: 1}, "1.0.0.1:80", 2, false},
+		{"failed first page", srv.URL + "/broken?page={page}", jsonPagination{Type: paginationPage, StartPage: 2}, "", 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mu.Lock()
+			requested = requested[:0]
+			mu.Unlock()
+			s := newTestJSONSource(t, &jsonSourceConfig{
+				Url:        c.url,
+				ListPath:   "data",
+				Fields:     jsonFields{Ip: "ip", Port: "port"},
+				Pagination: c.pagination,
+			})
+			proxies, sr := s.fetch(context.Background())
+			if c.fails != (sr.Err != nil) {
+				t.Fatalf("got error %v", sr.Err)
+			}
+			if got := proxyAddresses(proxies); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(requested) != c.requests {
+				t.Errorf("requested %v, want %d requests", requested, c.requests)
+			}
+		})
+	}
+}
+
+func TestJSONListPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result": {"proxies": [{"address": "1.2.3.4:80"}]}, "count": 1}`)
+	}))
+	defer srv.Close()
+	for path, fails := range map[string]bool{"result.proxies": false, "count": true, "result.missing": true} {
+		s := newTestJSONSource(t, &jsonSourceConfig{Url: srv.URL, ListPath: path, Fields: jsonFields{Address: "address"}})
+		proxies, sr := s.fetch(context.Background())
+		if fails {
+			if ErrorCategory(sr.Err) != ErrCategoryParse {
+				t.Errorf("%s: got %v, want a parse error", path, sr.Err)
+			}
+			continue
+		}
+		if sr.Err != nil || len(proxies) != 1 {
+			t.Errorf("%s: got %d proxies and %v", path, len(proxies), sr.Err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+type listURL struct {
+	Url      string          `mapstructure:"url"`
+	DialType public.DialType `mapstructure:"dial_type"`
+}
+
+type listSourceConfig struct {
+	Name    string    `mapstructure:"name"`
+	Urls    []listURL `mapstructure:"urls"`
+	Timeout int64     `mapstructure:"timeout"`
+	Proxy   string    `mapstructure:"proxy"`
+	Proxies []string  `mapstructure:"proxies"`
+}
+
+type listSource struct {
+	provider string
+	urls     []listURL
+	http     *httpFetcher
+}
+
+type listExecutor struct {
+	sources []*listSource
+}
+
+func newListSource(provider string, urls []listURL, proxies []string, timeout int64, retry retryPolicy) (*listSource, error) {
+	h, err := newHttpFetcher(proxies, time.Duration(timeout)*time.Second, retry)
+	if err != nil {
+		return nil, err
+	}
+	return &listSource{
+		provider: provider,
+		urls:     urls,
+		http:     h,
+	}, nil
+}
+
+func newListExecutor() *listExecutor {
+	logrus.Info("creating list executor")
+	timeout := viper.GetInt64("executor.list.timeout")
+	if timeout == 0 {
+		timeout = 5
+	}
+	proxies := upstreamURLs("executor.list")
+	retry := newRetryPolicy("executor.list")
+
+	configs := make([]*listSourceConfig, 0)
+	if err := viper.UnmarshalKey("executor.list.sources", &configs); err != nil {
+		logrus.WithError(err).Panic("failed to parse list executor sources")
+	}
+	f := &listExecutor{sources: make([]*listSource, 0, len(configs))}
+	for _, c := range configs {
+		if len(c.Name) == 0 || len(c.Urls) == 0 {
+			logrus.WithField("source", c).Error("list source needs a name and at least one url, skipped")
+			continue
+		}
+		valid := true
+		for i := range c.Urls {
+			if len(c.Urls[i].DialType) == 0 {
+				c.Urls[i].DialType = public.DialTypeHttp
+			}
+			if !c.Urls[i].DialType.Valid() {
+				valid = false
+			}
+		}
+		if !valid {
+			logrus.WithField("source", c).Error("unknown list source dial type, skipped")
+			continue
+		}
+		if c.Timeout == 0 {
+			c.Timeout = timeout
+		}
+		s, err := newListSource(strings.ToUpper(c.Name), c.Urls, sourceUpstreams(c.Proxies, c.Proxy, proxies), c.Timeout, retry)
+		if err != nil {
+			logrus.WithError(err).WithField("source", c.Name).Error("bad list source proxy, skipped")
+			continue
+		}
+		f.sources = append(f.sources, s)
+	}
+	if len(f.sources) == 0 {
+		logrus.Warn("list executor has no sources")
+	}
+	return f
+}
+
+func (f *listExecutor) Fetch(ctx context.Context) FetchResult {
+	logrus.WithField("provider", f.Type()).Info("fetch")
+	sources := make([]sourceFunc, 0)
+	for _, s := range f.sources {
+		sources = append(sources, s.sources()...)
+	}
+	return runSources(ctx, sources)
+}
+
+func (f *listExecutor) usePool(pool *proxyPool) error {
+	for _, s := range f.sources {
+		if err := s.http.usePool("executor.list", pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *listExecutor) Close() error {
+	for _, s := range f.sources {
+		s.http.close()
+	}
+	return nil
+}
+
+func (f *listExecutor) Type() string {
+	return public.ExecutorTypeList
+}
+
+func (s *listSource) fetch(ctx context.Context) FetchResult {
+	return runSources(ctx, s.sources())
+}
+
+func (s *listSource) sources() []sourceFunc {
+	sources := make([]sourceFunc, 0, len(s.urls))
+	for _, u := range s.urls {
+		u := u
+		sources = append(sources, func(ctx context.Context) ([]*Proxy, SourceResult) {
+			return s.fetchURL(ctx, u)
+		})
+	}
+	return sources
+}
+
+func (s *listSource) fetchURL(ctx context.Context, u listURL) ([]*Proxy, SourceResult) {
+	logrus.WithFields(logrus.Fields{
+		"provider": s.provider,
+		"type":     u.DialType,
+	}).Info("fetching proxy list")
+	sr := SourceResult{Name: s.provider, Url: u.Url}
+	res, err := s.http.get(ctx, u.Url, true)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"url":      u.Url,
+			"provider": s.provider,
+			"type":     u.DialType,
+		}).Error("failed to fetch proxy list")
+		sr.Err = err
+		return nil, sr
+	}
+	sr.Bytes = res.size
+	if res.notModified {
+		logrus.WithFields(logrus.Fields{
+			"provider": s.provider,
+			"type":     u.DialType,
+		}).Info("proxy list not modified")
+		sr.NotModified = true
+		return nil, sr
+	}
+	proxies := parsePlain(res.body, s.provider, u.DialType)
+	s.http.remember(u.Url, res)
+	return proxies, sr
+}
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"strings"
+	"time"
+)
+
+type tableColumns struct {
+	Host      *int `mapstructure:"host"`
+	Port      *int `mapstructure:"port"`
+	Protocol  *int `mapstructure:"protocol"`
+	Country   *int `mapstructure:"country"`
+	Anonymity *int `mapstructure:"anonymity"`
+}
+
+type tableRule struct {
+	Table       string                     `mapstructure:"table"`
+	Index       int                        `mapstructure:"index"`
+	SkipRows    int                        `mapstructure:"skip_rows"`
+	Columns     tableColumns               `mapstructure:"columns"`
+	DialType    public.DialType            `mapstructure:"dial_type"`
+	ProtocolMap map[string]public.DialType `mapstructure:"protocol_map"`
+}
+
+type tableSourceConfig struct {
+	Name      string   `mapstructure:"name"`
+	Url       string   `mapstructure:"url"`
+	Timeout   int64    `mapstructure:"timeout"`
+	Proxy     string   `mapstructure:"proxy"`
+	Proxies   []string `mapstructure:"proxies"`
+	tableRule `mapstructure:",squash"`
+}
+
+type tableSource struct {
+	provider string
+	url      string
+	rule     tableRule
+	http     *httpFetcher
+}
+
+type tableExecutor struct {
+	sources []*tableSource
+}
+
+func newTableExecutor() *tableExecutor {
+	logrus.Info("creating table executor")
+	timeout := viper.GetInt64("executor.table.timeout")
+	if timeout == 0 {
+		timeout = 15
+	}
+	proxies := upstreamURLs("executor.table")
+	retry := newRetryPolicy("executor.table")
+
+	configs := make([]*tableSourceConfig, 0)
+	if err := viper.UnmarshalKey("executor.table.sources", &configs); err != nil {
+		logrus.WithError(err).Panic("failed to parse table executor sources")
+	}
+	f := &tableExecutor{sources: make([]*tableSource, 0, len(configs))}
+	for _, c := range configs {
+		if len(c.Name) == 0 || len(c.Url) == 0 || c.Columns.Host == nil {
+			logrus.WithField("source", c).Error("table source needs a name, an url and a host column, skipped")
+			continue
+		}
+		if len(c.DialType) == 0 {
+			c.DialType = public.DialTypeHttp
+		}
+		if err := validDialTypes(c.DialType, c.ProtocolMap); err != nil {
+			logrus.WithError(err).WithField("source", c).Error("bad table source dial type, skipped")
+			continue
+		}
+		if len(c.Table) == 0 {
+			c.Table = "table"
+		}
+		if c.Timeout == 0 {
+			c.Timeout = timeout
+		}
+		h, err := newHttpFetcher(sourceUpstreams(c.Proxies, c.Proxy, proxies), time.Duration(c.Timeout)*time.Second, retry)
+		if err != nil {
+			logrus.WithError(err).WithField("source", c.Name).Error("bad table source proxy, skipped")
+			continue
+		}
+		f.sources = append(f.sources, &tableSource{
+			provider: strings.ToUpper(c.Name),
+			url:      c.Url,
+			rule:     c.tableRule,
+			http:     h,
+		})
+	}
+	if len(f.sources) == 0 {
+		logrus.Warn("table executor has no sources")
+	}
+	return f
+}
+
+func (f *tableExecutor) Fetch(ctx context.Context) FetchResult {
+	logrus.WithField("provider", f.Type()).Info("fetch")
+	sources := make([]sourceFunc, 0, len(f.sources))
+	for _, s := range f.sources {
+		sources = append(sources, s.fetch)
+	}
+	return runSources(ctx, sources)
+}
+
+func (f *tableExecutor) usePool(pool *proxyPool) error {
+	for _, s := range f.sources {
+		if err := s.http.usePool("executor.table", pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *tableExecutor) Close() error {
+	for _, s := range f.sources {
+		s.http.close()
+	}
+	return nil
+}
+
+func (f *tableExecutor) Type() string {
+	return public.ExecutorTypeTable
+}
+
+func (s *tableSource) fetch(ctx context.Context) ([]*Proxy, SourceResult) {
+	logrus.WithField("provider", s.provider).Info("fetching proxy table")
+	sr := SourceResult{Name: s.provider, Url: s.url}
+	res, err := s.http.get(ctx, s.url, true)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"url":      s.url,
+			"provider": s.provider,
+		}).Error("failed to fetch proxy table")
+		sr.Err = err
+		return nil, sr
+	}
+	sr.Bytes = res.size
+	if res.notModified {
+		logrus.WithField("provider", s.provider).Info("proxy table not modified")
+		sr.NotModified = true
+		return nil, sr
+	}
+	proxies, err := parseTable(res.body, s.provider, &s.rule)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"url":      s.url,
+			"provider": s.provider,
+		}).Error("failed to parse proxy table")
+		sr.Err = newFetchError(ErrCategoryParse, s.url, err)
+		return nil, sr
+	}
+	s.http.remember(s.url, res)
+	return proxies, sr
+}
+
+func parseTable(body []byte, provider string, rule *tableRule) ([]*Proxy, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	tables := findElements(doc, rule.Table)
+	if rule.Index >= len(tables) {
+		return nil, fmt.Errorf("table %q #%d not found, %d matched", rule.Table, rule.Index, len(tables))
+	}
+
+	proxies := make([]*Proxy, 0)
+	skipped := 0
+	for _, row := range tableRows(tables[rule.Index]) {
+		cells := rowCells(row)
+		if cells == nil {
+			continue
+		}
+		if skipped < rule.SkipRows {
+			skipped++
+			continue
+		}
+		host := cellAt(cells, rule.Columns.Host)
+		if len(host) == 0 {
+			continue
+		}
+		address := host
+		if port := cellAt(cells, rule.Columns.Port); len(port) != 0 {
+			address = joinHostPort(host, port)
+		}
+		p := newProxy(address, provider, mapDialType(cellAt(cells, rule.Columns.Protocol), rule.ProtocolMap, rule.DialType))
+		p.Country = cellAt(cells, rule.Columns.Country)
+		p.Anonymity = normalizeAnonymity(cellAt(cells, rule.Columns.Anonymity))
+		proxies = append(proxies, p)
+	}
+	return proxies, nil
+}
+
+// findElements matches a simple "tag#id.class" selector, every part is optional.
+func findElements(n *html.Node, selector string) []*html.Node {
+	tag, id, classes := parseSelector(selector)
+	found := make([]*html.Node, 0)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && matchElement(n, tag, id, classes) {
+			found = append(found, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return found
+}
+
+func parseSelector(selector string) (tag, id string, classes []string) {
+	parts := strings.Split(strings.TrimSpace(selector), ".")
+	tag, classes = parts[0], parts[1:]
+	if i := strings.Index(tag, "#"); i >= 0 {
+		tag, id = tag[:i], tag[i+1:]
+	}
+	return strings.ToLower(tag), id, classes
+}
+
+func matchElement(n *html.Node, tag, id string, classes []string) bool {
+	if len(tag) != 0 && n.Data != tag {
+		return false
+	}
+	if len(id) != 0 && attr(n, "id") != id {
+		return false
+	}
+	have := strings.Fields(attr(n, "class"))
+	for _, want := range classes {
+		matched := false
+		for _, c := range have {
+			if c == want {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// tableRows returns the rows of the table itself, rows of nested tables are ignored.
+func tableRows(table *html.Node) []*html.Node {
+	rows := make([]*html.Node, 0)
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Tr:
+			rows = append(rows, c)
+		case atom.Thead, atom.Tbody, atom.Tfoot:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.DataAtom == atom.Tr {
+					rows = append(rows, r)
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// rowCells returns the text of each td, header rows made of th return nil.
+func rowCells(row *html.Node) []string {
+	cells := make([]string, 0)
+	for c := row.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Th:
+			return nil
+		case atom.Td:
+			cells = append(cells, strings.TrimSpace(nodeText(c)))
+		}
+	}
+	if len(cells) == 0 {
+		return nil
+	}
+	return cells
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	sb := strings.Builder{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Script || c.DataAtom == atom.Style {
+			continue
+		}
+		sb.WriteString(nodeText(c))
+	}
+	return sb.String()
+}
+
+func cellAt(cells []string, index *int) string {
+	if index == nil || *index < 0 || *index >= len(cells) {
+		return ""
+	}
+	return cells[*index]
+}
+
+func normalizeAnonymity(raw string) string {
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	switch {
+	case len(raw) == 0:
+		return ""
+	case strings.Contains(raw, "elite"), strings.Contains(raw, "high"):
+		return public.AnonymityElite
+	case strings.Contains(raw, "transparent"):
+		return public.AnonymityTransparent
+	case strings.Contains(raw, "anonym"):
+		return public.AnonymityAnonymous
+	default:
+		return raw
+	}
+}
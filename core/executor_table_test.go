@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestParseTable(t *testing.T) {
+	body, err := os.ReadFile("testdata/table.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := &tableRule{
+		Table:    "table.proxies",
+		Index:    1,
+		SkipRows: 1,
+		Columns: tableColumns{
+			Host:      intPtr(0),
+			Port:      intPtr(1),
+			Protocol:  intPtr(2),
+			Country:   intPtr(3),
+			Anonymity: intPtr(4),
+		},
+		DialType:    public.DialTypeHttp,
+		ProtocolMap: map[string]public.DialType{"s4": public.DialTypeSocks4},
+	}
+	proxies, err := parseTable(body, "TEST", rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Proxy{
+		{Address: "1.2.3.4:8080", DialType: public.DialTypeHttp, Country: "US", Anonymity: public.AnonymityElite},
+		{Address: "5.6.7.8:1080", DialType: public.DialTypeSocks5, Country: "DE", Anonymity: public.AnonymityAnonymous},
+		{Address: "9.10.11.12:3128", DialType: public.DialTypeSocks4, Country: "FR", Anonymity: public.AnonymityTransparent},
+		{Address: "[2001:4860::8888]:443", DialType: public.DialTypeHttp, Country: "JP", Anonymity: public.AnonymityElite},
+		{Address: "13.14.15.16:8000", DialType: public.DialTypeHttp, Country: "NL", Anonymity: ""},
+	}
+	if len(proxies) != len(want) {
+		for _, p := range proxies {
+			t.Logf("got %+v", *p)
+		}
+		t.Fatalf("got %d proxies, want %d", len(proxies), len(want))
+	}
+	for i, w := range want {
+		p := proxies[i]
+		if p.Address != w.Address || p.DialType != w.DialType || p.Country != w.Country || p.Anonymity != w.Anonymity {
+			t.Errorf("proxy %d: got %s %s %q %q, want %s %s %q %q", i,
+				p.Address, p.DialType, p.Country, p.Anonymity, w.Address, w.DialType, w.Country, w.Anonymity)
+		}
+		if p.Provider != "TEST" {
+			t.Errorf("proxy %d: got provider %q", i, p.Provider)
+		}
+	}
+}
+
+func TestParseTableSelector(t *testing.T) {
+	body, err := os.ReadFile("testdata/table.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		table string
+		index int
+		count int
+		fails bool
+	}{
+		{table: "table", index: 0, count: 1},
+		{table: "table.sidebar", index: 0, count: 1},
+		{table: "table#list", index: 0, count: 6},
+		{table: "#list.proxies", index: 0, count: 6},
+		{table: "table.proxies", index: 2, fails: true},
+		{table: "table.missing", index: 0, fails: true},
+	}
+	for _, c := range cases {
+		rule := &tableRule{Table: c.table, Index: c.index, Columns: tableColumns{Host: intPtr(0), Port: intPtr(1)}, DialType: public.DialTypeHttp}
+		proxies, err := parseTable(body, "TEST", rule)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s #%d: want an error", c.table, c.index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s #%d: %v", c.table, c.index, err)
+			continue
+		}
+		if len(proxies) != c.count {
+			t.Errorf("%s #%d: got %d proxies, want %d", c.table, c.index, len(proxies), c.count)
+		}
+	}
+}
+
+func TestNormalizeAnonymity(t *testing.T) {
+	cases := map[string]string{
+		"":               "",
+		"  Elite proxy ": public.AnonymityElite,
+		"High Anonymous": public.AnonymityElite,
+		"anonymous":      public.AnonymityAnonymous,
+		"Anonymity":      public.AnonymityAnonymous,
+		"TRANSPARENT":    public.AnonymityTransparent,
+		"Distorting":     "distorting",
+	}
+	for raw, want := range cases {
+		if got := normalizeAnonymity(raw); got != want {
+			t.Errorf("normalizeAnonymity(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+// A page failing to parse mustn't leave its ETag behind, or it would never be parsed again.
+func TestTableSourceConditionalGet(t *testing.T) {
+	page, err := os.ReadFile("testdata/table.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("<html><body>maintenance</body></html>")
+	conditional := make([]bool, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf("%q", fmt.Sprintf("%x", len(body)))
+		conditional = append(conditional, len(r.Header.Get("If-None-Match")) != 0)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	h, err := newHttpFetcher(nil, 5*time.Second, retryPolicy{attempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	s := &tableSource{
+		provider: "TEST",
+		url:      srv.URL,
+		rule: tableRule{
+			Table:    "table#list",
+			SkipRows: 1,
+			Columns:  tableColumns{Host: intPtr(0), Port: intPtr(1)},
+			DialType: public.DialTypeHttp,
+		},
+		http: h,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, sr := s.fetch(context.Background()); ErrorCategory(sr.Err) != ErrCategoryParse {
+			t.Fatalf("got %v, want a parse error", sr.Err)
+		}
+	}
+	body = page
+	proxies, sr := s.fetch(context.Background())
+	if sr.Err != nil || len(proxies) != 5 {
+		t.Fatalf("got %d proxies and %v", len(proxies), sr.Err)
+	}
+	if _, sr := s.fetch(context.Background()); !sr.NotModified {
+		t.Fatalf("want the unchanged page to be skipped")
+	}
+	want := []bool{false, false, false, true}
+	if fmt.Sprint(conditional) != fmt.Sprint(want) {
+		t.Fatalf("conditional requests %v, want %v", conditional, want)
+	}
+}
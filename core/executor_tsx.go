@@ -1,21 +1,14 @@
 package core
 
 import (
-	"crypto/tls"
+	"context"
 	"github.com/JobberRT/pxier_fetcher/public"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpproxy"
-	"strings"
-	"time"
 )
 
 type tsxExecutor struct {
-	httpUrl   string
-	socks5Url string
-	client    *fasthttp.Client
-	timeout   time.Duration
+	source *listSource
 }
 
 func newTSXExecutor() *tsxExecutor {
@@ -28,126 +21,40 @@ func newTSXExecutor() *tsxExecutor {
 	if len(su) == 0 {
 		su = "https://raw.githubusercontent.com/TheSpeedX/SOCKS-List/master/socks5.txt"
 	}
+	s4u := viper.GetString("executor.tsx.socks4_url")
+	if len(s4u) == 0 {
+		s4u = "https://raw.githubusercontent.com/TheSpeedX/SOCKS-List/master/socks4.txt"
+	}
 	timeout := viper.GetInt64("executor.tsx.timeout")
 	if timeout == 0 {
 		timeout = 5
 	}
-	f := &tsxExecutor{
-		httpUrl:   hu,
-		socks5Url: su,
-		timeout:   time.Duration(timeout) * time.Second,
-		client:    &fasthttp.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+	urls := []listURL{
+		{Url: hu, DialType: public.DialTypeHttp},
+		{Url: s4u, DialType: public.DialTypeSocks4},
+		{Url: su, DialType: public.DialTypeSocks5},
 	}
-	proxy := viper.GetString("executor.tsx.proxy")
-	if len(proxy) != 0 {
-		if strings.Contains(proxy, "http") {
-			f.client.Dial = fasthttpproxy.FasthttpHTTPDialer(proxy)
-		} else {
-			f.client.Dial = fasthttpproxy.FasthttpSocksDialer(proxy)
-		}
+	source, err := newListSource(public.ExecutorTypeTSX, urls, upstreamURLs("executor.tsx"), timeout, newRetryPolicy("executor.tsx"))
+	if err != nil {
+		logrus.WithError(err).Panic("bad tsx executor proxy")
 	}
-	return f
+	return &tsxExecutor{source: source}
 }
 
-func (f *tsxExecutor) Fetch() []*proxy {
+func (f *tsxExecutor) Fetch(ctx context.Context) FetchResult {
 	logrus.WithField("provider", f.Type()).Info("fetch")
-	return append(f.fetchHttpProxy(), f.fetchSocks5Proxy()...)
+	return f.source.fetch(ctx)
 }
 
-func (f *tsxExecutor) Type() string {
-	return public.ExecutorTypeTSX
+func (f *tsxExecutor) usePool(pool *proxyPool) error {
+	return f.source.http.usePool("executor.tsx", pool)
 }
 
-func (f *tsxExecutor) fetchHttpProxy() []*proxy {
-	logrus.WithField("provider", f.Type()).Info("fetching http proxy")
-	req := fasthttp.AcquireRequest()
-	res := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(res)
-
-	req.SetRequestURI(f.httpUrl)
-	req.Header.SetMethod(fasthttp.MethodGet)
-	req.Header.SetContentEncoding("gzip")
-	if err := f.client.DoTimeout(req, res, f.timeout); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"url":      f.httpUrl,
-			"provider": f.Type(),
-			"type":     public.DialTypeHttp,
-		}).Error("failed to fetch http proxy")
-		return nil
-	}
-
-	body, err := readBody(res)
-	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"raw":      string(body),
-			"url":      f.httpUrl,
-			"provider": f.Type(),
-			"type":     public.DialTypeHttp,
-		}).Error("failed to unGzip body")
-		return nil
-	}
-	rawSlice := strings.Split(string(body), "\n")
-	proxies := make([]*proxy, 0)
-	for _, each := range rawSlice {
-		if len(each) == 0 {
-			continue
-		}
-		proxies = append(proxies, &proxy{
-			Address:   each,
-			ErrTimes:  0,
-			CreatedAt: time.Now().Unix(),
-			UpdatedAt: time.Now().Unix(),
-			Provider:  public.ExecutorTypeTSX,
-			DialType:  public.DialTypeHttp,
-		})
-	}
-	return proxies
+func (f *tsxExecutor) Close() error {
+	f.source.http.close()
+	return nil
 }
 
-func (f *tsxExecutor) fetchSocks5Proxy() []*proxy {
-	logrus.WithField("provider", f.Type()).Info("fetching socks5 proxy")
-	req := fasthttp.AcquireRequest()
-	res := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(res)
-
-	req.SetRequestURI(f.socks5Url)
-	req.Header.SetMethod(fasthttp.MethodGet)
-	req.Header.SetContentEncoding("gzip")
-	if err := f.client.DoTimeout(req, res, f.timeout); err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"url":      f.httpUrl,
-			"provider": f.Type(),
-			"type":     public.DialTypeHttp,
-		}).Error("failed to fetch socks5 proxy")
-		return nil
-	}
-
-	body, err := readBody(res)
-	if err != nil {
-		logrus.WithError(err).WithFields(logrus.Fields{
-			"raw":      string(body),
-			"url":      f.httpUrl,
-			"provider": f.Type(),
-			"type":     public.DialTypeHttp,
-		}).Error("failed to unGzip body")
-		return nil
-	}
-	rawSlice := strings.Split(string(body), "\n")
-	proxies := make([]*proxy, 0)
-	for _, each := range rawSlice {
-		if len(each) == 0 {
-			continue
-		}
-		proxies = append(proxies, &proxy{
-			Address:   each,
-			ErrTimes:  0,
-			CreatedAt: time.Now().Unix(),
-			UpdatedAt: time.Now().Unix(),
-			Provider:  public.ExecutorTypeTSX,
-			DialType:  public.DialTypeSocks5,
-		})
-	}
-	return proxies
+func (f *tsxExecutor) Type() string {
+	return public.ExecutorTypeTSX
 }
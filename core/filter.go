@@ -0,0 +1,347 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/fs"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	filterCIDR     = "cidr"
+	filterASN      = "asn"
+	filterCountry  = "country"
+	filterPort     = "port"
+	filterDialType = "dial_type"
+)
+
+// filterKinds are checked in this order, key is the name of their list in the config.
+var filterKinds = []struct {
+	kind string
+	key  string
+}{
+	{filterCIDR, "cidrs"},
+	{filterASN, "asns"},
+	{filterCountry, "countries"},
+	{filterPort, "ports"},
+	{filterDialType, "dial_types"},
+}
+
+type portRange struct {
+	from uint16
+	to   uint16
+}
+
+func (r portRange) String() string {
+	if r.from == r.to {
+		return strconv.Itoa(int(r.from))
+	}
+	return fmt.Sprintf("%d-%d", r.from, r.to)
+}
+
+type filterRules struct {
+	cidrs     []netip.Prefix
+	asns      map[uint]bool
+	countries map[string]bool
+	ports     []portRange
+	dialTypes map[public.DialType]bool
+}
+
+func newFilterRules() *filterRules {
+	return &filterRules{
+		cidrs:     make([]netip.Prefix, 0),
+		asns:      make(map[uint]bool),
+		countries: make(map[string]bool),
+		ports:     make([]portRange, 0),
+		dialTypes: make(map[public.DialType]bool),
+	}
+}
+
+// add parses value as a rule of kind, like 10.0.0.0/8, AS13335, CN, 25, 6000-6999 or socks4.
+func (r *filterRules) add(kind, value string) error {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case filterCIDR:
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return fmt.Errorf("bad cidr %q", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.cidrs = append(r.cidrs, prefix.Masked())
+	case filterASN:
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad asn %q", value)
+		}
+		r.asns[uint(asn)] = true
+	case filterCountry:
+		if len(value) == 0 {
+			return fmt.Errorf("empty country")
+		}
+		r.countries[strings.ToUpper(value)] = true
+	case filterPort:
+		from, to, found := strings.Cut(value, "-")
+		if !found {
+			to = from
+		}
+		a, errA := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		b, errB := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if errA != nil || errB != nil || a == 0 || a > b {
+			return fmt.Errorf("bad port %q", value)
+		}
+		r.ports = append(r.ports, portRange{from: uint16(a), to: uint16(b)})
+	case filterDialType:
+		dt, err := public.ParseDialType(strings.ToLower(value))
+		if err != nil {
+			return err
+		}
+		r.dialTypes[dt] = true
+	default:
+		return fmt.Errorf("unknown filter rule kind %q", kind)
+	}
+	return nil
+}
+
+func (r *filterRules) size() int {
+	return len(r.cidrs) + len(r.asns) + len(r.countries) + len(r.ports) + len(r.dialTypes)
+}
+
+// matchKind returns the rule of kind matching pxy like "cidr 10.0.0.0/8", or nothing.
+func (r *filterRules) matchKind(kind string, addr netip.AddrPort, pxy *Proxy) string {
+	switch kind {
+	case filterCIDR:
+		for _, prefix := range r.cidrs {
+			if addr.IsValid() && prefix.Contains(addr.Addr()) {
+				return "cidr " + prefix.String()
+			}
+		}
+	case filterASN:
+		if r.asns[pxy.ASN] {
+			return "asn " + strconv.FormatUint(uint64(pxy.ASN), 10)
+		}
+	case filterCountry:
+		if country := strings.ToUpper(pxy.Country); r.countries[country] {
+			return "country " + country
+		}
+	case filterPort:
+		for _, ports := range r.ports {
+			if addr.IsValid() && addr.Port() >= ports.from && addr.Port() <= ports.to {
+				return "port " + ports.String()
+			}
+		}
+	case filterDialType:
+		if r.dialTypes[pxy.DialType] {
+			return "dial_type " + string(pxy.DialType)
+		}
+	}
+	return ""
+}
+
+func (r *filterRules) kindSize(kind string) int {
+	switch kind {
+	case filterCIDR:
+		return len(r.cidrs)
+	case filterASN:
+		return len(r.asns)
+	case filterCountry:
+		return len(r.countries)
+	case filterPort:
+		return len(r.ports)
+	case filterDialType:
+		return len(r.dialTypes)
+	}
+	return 0
+}
+
+// denied returns the first rule matching pxy.
+func (r *filterRules) denied(addr netip.AddrPort, pxy *Proxy) string {
+	for _, k := range filterKinds {
+		if rule := r.matchKind(k.kind, addr, pxy); len(rule) != 0 {
+			return rule
+		}
+	}
+	return ""
+}
+
+// disallowed returns the first kind having rules that pxy matches none of, a kind without
+// rules allows everything.
+func (r *filterRules) disallowed(addr netip.AddrPort, pxy *Proxy) string {
+	for _, k := range filterKinds {
+		if r.kindSize(k.kind) != 0 && len(r.matchKind(k.kind, addr, pxy)) == 0 {
+			return k.kind
+		}
+	}
+	return ""
+}
+
+// proxyFilter drops proxies by the allow and deny rules under its config key, plus the deny
+// rules of the deny files, which are read again once changed. Dropped proxies are counted by rule.
+type proxyFilter struct {
+	scope     string
+	allow     *filterRules
+	deny      *filterRules
+	files     []string
+	fileRules *filterRules
+	counts    map[string]uint64
+	watcher   *fileWatcher
+	mu        sync.RWMutex
+}
+
+// newProxyFilter reads <key>.allow, <key>.deny and <key>.deny_files, it returns nil when there's no rule.
+// Scope names the filter in the rules it counts.
+func newProxyFilter(scope, key string) (*proxyFilter, error) {
+	f := &proxyFilter{
+		scope:     scope,
+		allow:     newFilterRules(),
+		deny:      newFilterRules(),
+		files:     viper.GetStringSlice(key + ".deny_files"),
+		fileRules: newFilterRules(),
+		counts:    make(map[string]uint64),
+	}
+	for _, k := range filterKinds {
+		for _, value := range viper.GetStringSlice(key + ".allow." + k.key) {
+			if err := f.allow.add(k.kind, value); err != nil {
+				return nil, fmt.Errorf("%s.allow.%s: %w", key, k.key, err)
+			}
+		}
+		for _, value := range viper.GetStringSlice(key + ".deny." + k.key) {
+			if err := f.deny.add(k.kind, value); err != nil {
+				return nil, fmt.Errorf("%s.deny.%s: %w", key, k.key, err)
+			}
+		}
+	}
+	if f.allow.size() == 0 && f.deny.size() == 0 && len(f.files) == 0 {
+		return nil, nil
+	}
+	if len(f.files) != 0 {
+		rules, err := readDenyFiles(f.files)
+		if err != nil {
+			return nil, err
+		}
+		f.fileRules = rules
+		f.watcher = watchFiles(scope+" deny files", f.files, f.reload)
+	}
+	logrus.WithFields(logrus.Fields{
+		"scope":      scope,
+		"allow":      f.allow.size(),
+		"deny":       f.deny.size(),
+		"deny_files": f.fileRules.size(),
+	}).Info("proxy filter loaded")
+	return f, nil
+}
+
+// readDenyFiles parses one rule per line: an ip or cidr, an asn like AS13335, or kind:value
+// like country:CN, port:25, port:6000-6999 or dial_type:socks4. Missing files are skipped,
+// they're read once they're created.
+func readDenyFiles(files []string) (*filterRules, error) {
+	rules := newFilterRules()
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			logrus.WithField("file", file).Warn("deny file doesn't exist, its rules apply once it's created")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range listLines(body) {
+			kind, value := filterCIDR, line
+			// ipv6 addresses have more than one colon
+			switch {
+			case strings.Count(line, ":") == 1 && !strings.Contains(line, "/"):
+				kind, value, _ = strings.Cut(line, ":")
+				kind = strings.ToLower(kind)
+			case strings.HasPrefix(strings.ToUpper(line), "AS"):
+				kind = filterASN
+			}
+			if err := rules.add(kind, value); err != nil {
+				return nil, fmt.Errorf("bad rule %q in %s, line %d: %w", line, file, i+1, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func (f *proxyFilter) reload() {
+	rules, err := readDenyFiles(f.files)
+	if err != nil {
+		logrus.WithError(err).WithField("scope", f.scope).Error("failed to reload deny files, keeping the old rules")
+		return
+	}
+	f.mu.Lock()
+	f.fileRules = rules
+	f.mu.Unlock()
+	logrus.WithFields(logrus.Fields{
+		"scope": f.scope,
+		"rules": rules.size(),
+	}).Info("deny files reloaded")
+}
+
+// check returns the rule dropping pxy like "global deny cidr 10.0.0.0/8" or "list allow country",
+// or nothing when pxy passes.
+func (f *proxyFilter) check(pxy *Proxy) string {
+	addr, _ := netip.ParseAddrPort(pxy.Address)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule := ""
+	if r := f.deny.denied(addr, pxy); len(r) != 0 {
+		rule = f.scope + " deny " + r
+	} else if r := f.fileRules.denied(addr, pxy); len(r) != 0 {
+		rule = f.scope + " deny_files " + r
+	} else if r := f.allow.disallowed(addr, pxy); len(r) != 0 {
+		rule = f.scope + " allow " + r
+	}
+	if len(rule) != 0 {
+		f.counts[rule]++
+	}
+	return rule
+}
+
+// snapshot copies the counts into counts.
+func (f *proxyFilter) snapshot(counts map[string]uint64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for rule, n := range f.counts {
+		counts[rule] += n
+	}
+}
+
+func (f *proxyFilter) close() {
+	if f.watcher != nil {
+		f.watcher.close()
+	}
+}
+
+// filterProxies returns the proxies passing every filter, nil filters are skipped. The dropped
+// ones are counted by rule.
+func filterProxies(proxies []*Proxy, filters ...*proxyFilter) ([]*Proxy, map[string]int) {
+	kept := make([]*Proxy, 0, len(proxies))
+	counts := make(map[string]int)
+	for _, pxy := range proxies {
+		rule := ""
+		for _, f := range filters {
+			if f == nil {
+				continue
+			}
+			if rule = f.check(pxy); len(rule) != 0 {
+				break
+			}
+		}
+		if len(rule) != 0 {
+			counts[rule]++
+			continue
+		}
+		kept = append(kept, pxy)
+	}
+	return kept, counts
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/spf13/viper"
+)
+
+func TestFilterRulesAdd(t *testing.T) {
+	cases := []struct {
+		kind  string
+		value string
+		fails bool
+	}{
+		{kind: filterCIDR, value: "10.0.0.0/8"},
+		{kind: filterCIDR, value: "1.2.3.4"},
+		{kind: filterCIDR, value: "2001:db8::/32"},
+		{kind: filterCIDR, value: " 2001:db8::1 "},
+		{kind: filterCIDR, value: "10.0.0.0/33", fails: true},
+		{kind: filterCIDR, value: "example.com", fails: true},
+		{kind: filterASN, value: "13335"},
+		{kind: filterASN, value: "AS13335"},
+		{kind: filterASN, value: "as13335"},
+		{kind: filterASN, value: "AS", fails: true},
+		{kind: filterASN, value: "AS-1", fails: true},
+		{kind: filterCountry, value: "cn"},
+		{kind: filterCountry, value: " ", fails: true},
+		{kind: filterPort, value: "25"},
+		{kind: filterPort, value: "6000-6999"},
+		{kind: filterPort, value: "6000 - 6999"},
+		{kind: filterPort, value: "0", fails: true},
+		{kind: filterPort, value: "65536", fails: true},
+		{kind: filterPort, value: "7000-6000", fails: true},
+		{kind: filterPort, value: "smtp", fails: true},
+		{kind: filterDialType, value: "SOCKS4"},
+		{kind: filterDialType, value: "ftp", fails: true},
+		{kind: "city", value: "Tokyo", fails: true},
+	}
+	for _, c := range cases {
+		err := newFilterRules().add(c.kind, c.value)
+		if c.fails != (err != nil) {
+			t.Errorf("add(%s, %q) = %v, want fails %v", c.kind, c.value, err, c.fails)
+		}
+	}
+}
+
+func testFilterRules(t *testing.T, rules map[string][]string) *filterRules {
+	t.Helper()
+	r := newFilterRules()
+	for kind, values := range rules {
+		for _, value := range values {
+			if err := r.add(kind, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return r
+}
+
+func TestFilterRulesDenied(t *testing.T) {
+	r := testFilterRules(t, map[string][]string{
+		filterCIDR:     {"10.0.0.0/8", "1.2.3.4", "2001:db8::/32"},
+		filterASN:      {"AS13335"},
+		filterCountry:  {"cn"},
+		filterPort:     {"25", "6660-6669"},
+		filterDialType: {"socks4"},
+	})
+	cases := []struct {
+		pxy  *Proxy
+		want string
+	}{
+		{&Proxy{Address: "5.6.7.8:80", DialType: public.DialTypeHttp}, ""},
+		{&Proxy{Address: "10.1.2.3:80", DialType: public.DialTypeHttp}, "cidr 10.0.0.0/8"},
+		{&Proxy{Address: "1.2.3.4:80", DialType: public.DialTypeHttp}, "cidr 1.2.3.4/32"},
+		{&Proxy{Address: "[2001:db8::1]:80", DialType: public.DialTypeHttp}, "cidr 2001:db8::/32"},
+		{&Proxy{Address: "5.6.7.8:80", DialType: public.DialTypeHttp, ASN: 13335}, "asn 13335"},
+		{&Proxy{Address: "5.6.7.8:80", DialType: public.DialTypeHttp, Country: "cn"}, "country CN"},
+		{&Proxy{Address: "5.6.7.8:25", DialType: public.DialTypeHttp}, "port 25"},
+		{&Proxy{Address: "5.6.7.8:6667", DialType: public.DialTypeHttp}, "port 6660-6669"},
+		{&Proxy{Address: "5.6.7.8:6670", DialType: public.DialTypeHttp}, ""},
+		{&Proxy{Address: "5.6.7.8:80", DialType: public.DialTypeSocks4}, "dial_type socks4"},
+		{&Proxy{Address: "5.6.7.8:80", DialType: public.DialTypeSocks4a}, ""},
+		// cidrs are checked first
+		{&Proxy{Address: "10.1.2.3:25", DialType: public.DialTypeSocks4, Country: "CN"}, "cidr 10.0.0.0/8"},
+		{&Proxy{Address: "garbage", DialType: public.DialTypeHttp}, ""},
+	}
+	for _, c := range cases {
+		addr, _ := netip.ParseAddrPort(c.pxy.Address)
+		if got := r.denied(addr, c.pxy); got != c.want {
+			t.Errorf("denied(%+v) = %q, want %q", c.pxy, got, c.want)
+		}
+	}
+}
+
+func TestFilterRulesDisallowed(t *testing.T) {
+	r := testFilterRules(t, map[string][]string{
+		filterCountry: {"US", "DE"},
+		filterPort:    {"80", "8000-8999"},
+	})
+	cases := []struct {
+		pxy  *Proxy
+		want string
+	}{
+		{&Proxy{Address: "5.6.7.8:80", Country: "US"}, ""},
+		{&Proxy{Address: "5.6.7.8:8080", Country: "de"}, ""},
+		{&Proxy{Address: "5.6.7.8:80", Country: "CN"}, filterCountry},
+		{&Proxy{Address: "5.6.7.8:80"}, filterCountry},
+		{&Proxy{Address: "5.6.7.8:3128", Country: "US"}, filterPort},
+		{&Proxy{Address: "garbage", Country: "US"}, filterPort},
+	}
+	for _, c := range cases {
+		addr, _ := netip.ParseAddrPort(c.pxy.Address)
+		if got := r.disallowed(addr, c.pxy); got != c.want {
+			t.Errorf("disallowed(%+v) = %q, want %q", c.pxy, got, c.want)
+		}
+	}
+	if got := newFilterRules().disallowed(netip.AddrPort{}, &Proxy{}); len(got) != 0 {
+		t.Errorf("empty allow rules disallowed %q", got)
+	}
+}
+
+func TestReadDenyFiles(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "deny.txt")
+	body := "# deny list\n" +
+		"1.2.3.4\n" +
+		"10.0.0.0/8 # private\n" +
+		"2001:db8::1\n" +
+		"2001:db8::/32\n" +
+		"::1\n" +
+		"AS13335\n" +
+		"as15169\n" +
+		"country:cn\n" +
+		"COUNTRY:DE\n" +
+		"port:25\n" +
+		"port:6000-6999\n" +
+		"dial_type:socks4\n" +
+		"\n"
+	if err := os.WriteFile(good, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := readDenyFiles([]string{good, filepath.Join(dir, "missing.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%v %d %d %v %d", rules.cidrs, len(rules.asns), len(rules.countries), rules.ports, len(rules.dialTypes))
+	want := "[1.2.3.4/32 10.0.0.0/8 2001:db8::1/128 2001:db8::/32 ::1/128] 2 2 [25 6000-6999] 1"
+	if got != want {
+		t.Errorf("got rules %s, want %s", got, want)
+	}
+	if !rules.asns[13335] || !rules.asns[15169] || !rules.countries["CN"] || !rules.countries["DE"] {
+		t.Errorf("got asns %v and countries %v", rules.asns, rules.countries)
+	}
+
+	for _, line := range []string{"city:Tokyo", "country:", "port:0", "AS12x", "1.2.3.4:80", "example.com"} {
+		bad := filepath.Join(dir, "bad.txt")
+		if err := os.WriteFile(bad, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readDenyFiles([]string{good, bad}); err == nil {
+			t.Errorf("%q: want an error", line)
+		}
+	}
+}
+
+func TestFilterProxies(t *testing.T) {
+	global := &proxyFilter{
+		scope:     "global",
+		allow:     newFilterRules(),
+		deny:      testFilterRules(t, map[string][]string{filterCIDR: {"5.0.0.0/8"}}),
+		fileRules: testFilterRules(t, map[string][]string{filterASN: {"13335"}}),
+		counts:    make(map[string]uint64),
+	}
+	list := &proxyFilter{
+		scope:     "list",
+		allow:     testFilterRules(t, map[string][]string{filterCountry: {"US"}}),
+		deny:      testFilterRules(t, map[string][]string{filterPort: {"25"}}),
+		fileRules: newFilterRules(),
+		counts:    make(map[string]uint64),
+	}
+	proxies := []*Proxy{
+		{Address: "1.2.3.4:80", Country: "US"},
+		{Address: "5.6.7.8:80", Country: "US"},
+		{Address: "1.2.3.5:80", Country: "US", ASN: 13335},
+		{Address: "1.2.3.6:80", Country: "CN"},
+		{Address: "1.2.3.7:25", Country: "US"},
+		{Address: "5.6.7.9:25", Country: "CN"},
+		{Address: "1.2.3.8:8080", Country: "US"},
+	}
+	kept, counts := filterProxies(proxies, nil, global, list)
+	addresses := make([]string, 0, len(kept))
+	for _, pxy := range kept {
+		addresses = append(addresses, pxy.Address)
+	}
+	if fmt.Sprint(addresses) != "[1.2.3.4:80 1.2.3.8:8080]" {
+		t.Errorf("kept %v", addresses)
+	}
+	want := map[string]int{
+		"global deny cidr 5.0.0.0/8":  2,
+		"global deny_files asn 13335": 1,
+		"list allow country":          1,
+		"list deny port 25":           1,
+	}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+	totals := make(map[string]uint64)
+	global.snapshot(totals)
+	list.snapshot(totals)
+	if len(totals) != len(want) || totals["global deny cidr 5.0.0.0/8"] != 2 {
+		t.Errorf("got totals %v", totals)
+	}
+}
+
+// A deny file missing at startup is read once it's created.
+func TestProxyFilterMissingDenyFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "deny.txt")
+	viper.Set("filter.deny_files", []string{file})
+	t.Cleanup(viper.Reset)
+	f, err := newProxyFilter("global", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.close()
+	pxy := &Proxy{Address: "1.2.3.4:80", DialType: public.DialTypeHttp}
+	if rule := f.check(pxy); len(rule) != 0 {
+		t.Fatalf("dropped by %s without rules", rule)
+	}
+
+	if err := os.WriteFile(file+".tmp", []byte("1.2.3.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if rule := f.check(pxy); rule == "global deny_files cidr 1.2.3.0/24" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("created deny file wasn't read")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
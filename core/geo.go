@@ -0,0 +1,172 @@
+package core
+
+import (
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net"
+	"sync"
+)
+
+type geoInfo struct {
+	country string
+	city    string
+	asn     uint
+	org     string
+}
+
+type geoCityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type geoASNRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoLocator looks addresses up in the local MaxMind databases at geoip.city_db and geoip.asn_db.
+// A changed database file is opened again and announced on changed.
+type geoLocator struct {
+	cityPath string
+	asnPath  string
+	city     *maxminddb.Reader
+	asn      *maxminddb.Reader
+	version  string
+	watcher  *fileWatcher
+	changed  chan struct{}
+	mu       sync.RWMutex
+}
+
+// newGeoLocator opens the configured databases, it returns nil when there's none.
+func newGeoLocator() (*geoLocator, error) {
+	g := &geoLocator{
+		cityPath: viper.GetString("geoip.city_db"),
+		asnPath:  viper.GetString("geoip.asn_db"),
+		changed:  make(chan struct{}, 1),
+	}
+	if len(g.cityPath) == 0 && len(g.asnPath) == 0 {
+		return nil, nil
+	}
+	if err := g.open(); err != nil {
+		return nil, err
+	}
+	g.watcher = watchFiles("geoip", []string{g.cityPath, g.asnPath}, func() {
+		if err := g.open(); err != nil {
+			logrus.WithError(err).Error("failed to reload geoip databases, keeping the old ones")
+		}
+	})
+	return g, nil
+}
+
+// open replaces the readers with the current files. The version changes with the build
+// time of the databases.
+func (g *geoLocator) open() error {
+	var city, asn *maxminddb.Reader
+	var err error
+	if len(g.cityPath) != 0 {
+		if city, err = maxminddb.Open(g.cityPath); err != nil {
+			return fmt.Errorf("failed to open %s: %w", g.cityPath, err)
+		}
+	}
+	if len(g.asnPath) != 0 {
+		if asn, err = maxminddb.Open(g.asnPath); err != nil {
+			if city != nil {
+				city.Close()
+			}
+			return fmt.Errorf("failed to open %s: %w", g.asnPath, err)
+		}
+	}
+	version := ""
+	for _, r := range []*maxminddb.Reader{city, asn} {
+		if r != nil {
+			version += fmt.Sprintf("%s@%d;", r.Metadata.DatabaseType, r.Metadata.BuildEpoch)
+		}
+	}
+
+	g.mu.Lock()
+	oldCity, oldASN := g.city, g.asn
+	changed := len(g.version) != 0 && g.version != version
+	g.city, g.asn, g.version = city, asn, version
+	g.mu.Unlock()
+	for _, r := range []*maxminddb.Reader{oldCity, oldASN} {
+		if r != nil {
+			r.Close()
+		}
+	}
+	logrus.WithField("version", version).Info("geoip databases loaded")
+	if changed {
+		select {
+		case g.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (g *geoLocator) currentVersion() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.version
+}
+
+// lookup finds the location and network of host, which has to be an ip, nothing is resolved.
+func (g *geoLocator) lookup(host string) geoInfo {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	info := geoInfo{}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return info
+	}
+	if g.city != nil {
+		record := &geoCityRecord{}
+		if err := g.city.Lookup(ip, record); err == nil {
+			info.country = record.Country.ISOCode
+			info.city = record.City.Names["en"]
+		}
+	}
+	if g.asn != nil {
+		record := &geoASNRecord{}
+		if err := g.asn.Lookup(ip, record); err == nil {
+			info.asn = record.Number
+			info.org = record.Org
+		}
+	}
+	return info
+}
+
+// enrich fills the geo columns of pxy, a located country replaces what the provider claims.
+func (g *geoLocator) enrich(pxy *Proxy) {
+	host, _, err := splitHostPort(pxy.Address)
+	if err != nil {
+		return
+	}
+	info := g.lookup(host)
+	if len(info.country) != 0 {
+		pxy.Country = info.country
+	}
+	pxy.City = info.city
+	pxy.ASN = info.asn
+	pxy.Org = info.org
+}
+
+// close stops the watcher first, so a pending reload can't reopen the readers afterwards.
+func (g *geoLocator) close() {
+	if g.watcher != nil {
+		g.watcher.close()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, r := range []*maxminddb.Reader{g.city, g.asn} {
+		if r != nil {
+			r.Close()
+		}
+	}
+	g.city, g.asn = nil, nil
+}
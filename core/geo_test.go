@@ -0,0 +1,155 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	body, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written aside and renamed in place like geoipupdate does
+	if err := os.WriteFile(dst+".tmp", body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(dst+".tmp", dst); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestGeoLocator(t *testing.T) (*geoLocator, string) {
+	t.Helper()
+	dir := t.TempDir()
+	copyFile(t, "testdata/city.mmdb", filepath.Join(dir, "city.mmdb"))
+	copyFile(t, "testdata/asn.mmdb", filepath.Join(dir, "asn.mmdb"))
+	viper.Set("geoip.city_db", filepath.Join(dir, "city.mmdb"))
+	viper.Set("geoip.asn_db", filepath.Join(dir, "asn.mmdb"))
+	t.Cleanup(viper.Reset)
+	g, err := newGeoLocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.close)
+	return g, dir
+}
+
+func TestGeoLocatorLookup(t *testing.T) {
+	g, _ := newTestGeoLocator(t)
+	cases := []struct {
+		host string
+		want geoInfo
+	}{
+		{"1.1.1.1", geoInfo{country: "AU", city: "Sydney", asn: 13335, org: "Cloudflare"}},
+		{"8.8.8.8", geoInfo{country: "US", city: "Mountain View", asn: 15169, org: "Google"}},
+		{"2001:4860::8888", geoInfo{country: "US", city: "Mountain View", asn: 15169, org: "Google"}},
+		{"9.9.9.9", geoInfo{}},
+		{"dns.google", geoInfo{}},
+	}
+	for _, c := range cases {
+		if got := g.lookup(c.host); got != c.want {
+			t.Errorf("lookup(%s) = %+v, want %+v", c.host, got, c.want)
+		}
+	}
+}
+
+func TestGeoLocatorEnrich(t *testing.T) {
+	g, _ := newTestGeoLocator(t)
+	located := &Proxy{Address: "1.1.1.1:80", Country: "CN"}
+	g.enrich(located)
+	if located.Country != "AU" || located.City != "Sydney" || located.ASN != 13335 || located.Org != "Cloudflare" {
+		t.Errorf("got %+v", located)
+	}
+	ipv6 := &Proxy{Address: "[2001:4860::8888]:8080"}
+	g.enrich(ipv6)
+	if ipv6.Country != "US" || ipv6.ASN != 15169 {
+		t.Errorf("got %+v", ipv6)
+	}
+	// the provider's claim is kept when the address isn't located
+	unknown := &Proxy{Address: "9.9.9.9:80", Country: "CH"}
+	g.enrich(unknown)
+	if unknown.Country != "CH" || unknown.ASN != 0 || len(unknown.City) != 0 {
+		t.Errorf("got %+v", unknown)
+	}
+}
+
+func TestGeoLocatorReload(t *testing.T) {
+	g, dir := newTestGeoLocator(t)
+	version := g.currentVersion()
+
+	// opening the same databases again isn't a change
+	if err := g.open(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-g.changed:
+		t.Fatal("unchanged databases announced a change")
+	default:
+	}
+	if g.currentVersion() != version {
+		t.Fatalf("version changed from %s to %s", version, g.currentVersion())
+	}
+
+	copyFile(t, "testdata/city-v2.mmdb", filepath.Join(dir, "city.mmdb"))
+	select {
+	case <-g.changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replaced database wasn't reloaded")
+	}
+	if g.currentVersion() == version {
+		t.Fatal("version didn't change")
+	}
+	if got := g.lookup("1.1.1.1"); got.country != "JP" || got.city != "Tokyo" || got.asn != 13335 {
+		t.Fatalf("got %+v after reload", got)
+	}
+}
+
+func TestEnricherVersion(t *testing.T) {
+	g, dir := newTestGeoLocator(t)
+	e := &enricher{geo: g}
+	pxy := &Proxy{Address: "1.1.1.1:80"}
+	e.enrich(pxy)
+	if pxy.EnrichVersion != e.version() || len(pxy.EnrichVersion) == 0 {
+		t.Fatalf("enriched with version %q, current %q", pxy.EnrichVersion, e.version())
+	}
+	copyFile(t, "testdata/city-v2.mmdb", filepath.Join(dir, "city.mmdb"))
+	select {
+	case <-g.changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replaced database wasn't reloaded")
+	}
+	// rows enriched by the older database are the ones refresh picks up
+	if pxy.EnrichVersion == e.version() {
+		t.Fatal("enrich version didn't follow the database")
+	}
+	e.enrich(pxy)
+	if pxy.Country != "JP" || pxy.EnrichVersion != e.version() {
+		t.Fatalf("got %+v", pxy)
+	}
+}
+
+// A reload pending when the locator is closed mustn't reopen the databases.
+func TestGeoLocatorCloseDuringReload(t *testing.T) {
+	g, dir := newTestGeoLocator(t)
+	copyFile(t, "testdata/city-v2.mmdb", filepath.Join(dir, "city.mmdb"))
+	// let the watcher see the change and schedule the reload
+	time.Sleep(200 * time.Millisecond)
+	g.close()
+	time.Sleep(1500 * time.Millisecond)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.city != nil || g.asn != nil {
+		t.Fatal("databases reopened after close")
+	}
+	select {
+	case <-g.changed:
+		t.Fatal("reloaded after close")
+	default:
+	}
+}
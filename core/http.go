@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"sync"
+	"time"
+)
+
+type httpFetcher struct {
+	client     *fasthttp.Client
+	timeout    time.Duration
+	retry      retryPolicy
+	upstreams  *upstreamPool
+	bootstrap  *bootstrap
+	validators map[string]*cacheValidator
+	mu         sync.Mutex
+}
+
+type response struct {
+	body        []byte
+	size        int
+	notModified bool
+	validator   *cacheValidator
+}
+
+type cacheValidator struct {
+	etag         string
+	lastModified string
+}
+
+// newHttpFetcher returns a fetcher sending requests directly, or through proxies when there are any.
+func newHttpFetcher(proxies []string, timeout time.Duration, retry retryPolicy) (*httpFetcher, error) {
+	upstreams, err := newUpstreamPool(proxies, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &httpFetcher{
+		timeout:    timeout,
+		retry:      retry,
+		upstreams:  upstreams,
+		client:     &fasthttp.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+		validators: make(map[string]*cacheValidator),
+	}, nil
+}
+
+// do sends req and fills res, network errors, 429 and 5xx responses are retried with backoff.
+func (h *httpFetcher) do(ctx context.Context, req *fasthttp.Request, res *fasthttp.Response) error {
+	for attempt := 1; ; attempt++ {
+		err := h.send(ctx, req, res)
+		if ctx.Err() != nil || attempt >= h.retry.attempts {
+			return err
+		}
+		if err == nil && !retryableStatus(res.StatusCode()) {
+			return nil
+		}
+
+		delay := h.retry.backoff(attempt)
+		entry := logrus.WithFields(logrus.Fields{
+			"url":     string(req.RequestURI()),
+			"attempt": attempt,
+			"delay":   delay.String(),
+		})
+		if err != nil {
+			entry = entry.WithError(err)
+		} else {
+			entry = entry.WithField("status", res.StatusCode())
+		}
+		entry.Warn("request failed, retrying")
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		res.Reset()
+	}
+}
+
+func retryableStatus(code int) bool {
+	return code == fasthttp.StatusTooManyRequests || code >= fasthttp.StatusInternalServerError
+}
+
+func (h *httpFetcher) send(ctx context.Context, req *fasthttp.Request, res *fasthttp.Response) error {
+	if h.bootstrap != nil {
+		return h.bootstrap.do(ctx, req, res, h.attempt)
+	}
+	if h.upstreams != nil {
+		return h.upstreams.do(ctx, req, res, h.attempt)
+	}
+	return h.attempt(ctx, h.client, req, res)
+}
+
+// usePool makes the fetcher go through pool proxies when <key>.bootstrap is enabled.
+func (h *httpFetcher) usePool(key string, pool *proxyPool) error {
+	b, err := newBootstrap(key, pool, h.timeout)
+	if err != nil {
+		return err
+	}
+	h.bootstrap = b
+	return nil
+}
+
+// attempt returns as soon as ctx is done. fasthttp can't abort a request, so the
+// abandoned one finishes within the timeout on its own copies of req and res.
+func (h *httpFetcher) attempt(ctx context.Context, client *fasthttp.Client, req *fasthttp.Request, res *fasthttp.Response) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := fasthttp.AcquireRequest()
+	s := fasthttp.AcquireResponse()
+	req.CopyTo(r)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.DoTimeout(r, s, h.timeout)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			s.CopyTo(res)
+		}
+		fasthttp.ReleaseRequest(r)
+		fasthttp.ReleaseResponse(s)
+		return err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			fasthttp.ReleaseRequest(r)
+			fasthttp.ReleaseResponse(s)
+		}()
+		return ctx.Err()
+	}
+}
+
+func (h *httpFetcher) close() {
+	h.client.CloseIdleConnections()
+	if h.upstreams != nil {
+		h.upstreams.close()
+	}
+	if h.bootstrap != nil {
+		h.bootstrap.close()
+	}
+}
+
+// get downloads url, a conditional get sends the ETag and Last-Modified of the last response
+// for url that was passed to remember.
+func (h *httpFetcher) get(ctx context.Context, url string, conditional bool) (*response, error) {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.SetContentEncoding("gzip")
+	if conditional {
+		h.mu.Lock()
+		if v, ok := h.validators[url]; ok {
+			if len(v.etag) != 0 {
+				req.Header.Set(fasthttp.HeaderIfNoneMatch, v.etag)
+			}
+			if len(v.lastModified) != 0 {
+				req.Header.Set(fasthttp.HeaderIfModifiedSince, v.lastModified)
+			}
+		}
+		h.mu.Unlock()
+	}
+	if err := h.do(ctx, req, res); err != nil {
+		return nil, requestError(ctx, url, err)
+	}
+	code := res.StatusCode()
+	if conditional && code == fasthttp.StatusNotModified {
+		return &response{notModified: true}, nil
+	}
+	if code < fasthttp.StatusOK || code >= fasthttp.StatusMultipleChoices {
+		return nil, &FetchError{
+			Category:   ErrCategoryStatus,
+			Url:        url,
+			StatusCode: code,
+			Err:        fmt.Errorf("unexpected status code %d", code),
+		}
+	}
+	size := len(res.Body())
+	body, err := readBody(res)
+	if err != nil {
+		return nil, newFetchError(ErrCategoryDecode, url, err)
+	}
+
+	return &response{
+		body: append([]byte(nil), body...),
+		size: size,
+		validator: &cacheValidator{
+			etag:         string(res.Header.Peek(fasthttp.HeaderETag)),
+			lastModified: string(res.Header.Peek(fasthttp.HeaderLastModified)),
+		},
+	}, nil
+}
+
+// remember keeps the ETag and Last-Modified of res for the next conditional get of url. Callers
+// do it once the body is parsed, so a body failing to parse is downloaded again.
+func (h *httpFetcher) remember(url string, res *response) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v := res.validator; v != nil && (len(v.etag) != 0 || len(v.lastModified) != 0) {
+		h.validators[url] = v
+	} else {
+		delete(h.validators, url)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// judgeHeaders are added by proxies revealing that a request went through a proxy.
+var judgeHeaders = []string{
+	"Via",
+	"X-Forwarded-For",
+	"Forwarded",
+	"X-Real-Ip",
+	"Client-Ip",
+	"X-Client-Ip",
+	"X-Proxy-Id",
+	"Proxy-Connection",
+}
+
+// judgeReport is what the judge answers, the address and headers of the request it received.
+type judgeReport struct {
+	RemoteAddr string      `json:"remote_addr"`
+	Headers    http.Header `json:"headers"`
+}
+
+func judgeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	json.NewEncoder(w).Encode(&judgeReport{RemoteAddr: host, Headers: r.Header})
+}
+
+// newJudgeServer returns the judge listening on judge.listen, or nil when it isn't set.
+func newJudgeServer() *http.Server {
+	listen := viper.GetString("judge.listen")
+	if len(listen) == 0 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", judgeHandler)
+	return &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// runJudge serves the judge until ctx is done.
+func runJudge(ctx context.Context, srv *http.Server) {
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	logrus.WithField("listen", srv.Addr).Info("judge started")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.WithError(err).Error("judge stopped")
+	}
+}
+
+// classifyAnonymity tells how much a proxy reveals from the report of a request sent through it:
+// transparent proxies leak origin, anonymous ones only reveal that they are proxies.
+func classifyAnonymity(origin string, report *judgeReport) string {
+	if sameIP(report.RemoteAddr, origin) {
+		return public.AnonymityTransparent
+	}
+	revealed := false
+	for _, name := range judgeHeaders {
+		for _, value := range report.Headers.Values(name) {
+			revealed = true
+			if containsIP(value, origin) {
+				return public.AnonymityTransparent
+			}
+		}
+	}
+	if revealed {
+		return public.AnonymityAnonymous
+	}
+	return public.AnonymityElite
+}
+
+// containsIP looks for ip in a header value like "1.2.3.4, 5.6.7.8" or "for=\"[::1]:80\"".
+func containsIP(value, ip string) bool {
+	tokens := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(",; =\"", r)
+	})
+	for _, token := range tokens {
+		if host, _, err := net.SplitHostPort(token); err == nil {
+			token = host
+		}
+		if sameIP(strings.Trim(token, "[]"), ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameIP compares a and b as ips when both parse, so differently written ipv6 addresses
+// and ipv4-mapped ones match.
+func sameIP(a, b string) bool {
+	x, errX := netip.ParseAddr(a)
+	y, errY := netip.ParseAddr(b)
+	if errX != nil || errY != nil {
+		return a == b
+	}
+	return x.Unmap().WithZone("") == y.Unmap().WithZone("")
+}
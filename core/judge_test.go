@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func TestPublicURL(t *testing.T) {
+	cases := map[string]bool{
+		"http://203.0.113.7:8090/":  false,
+		"http://8.8.8.8:8090/":      true,
+		"https://judge.example.com": true,
+		"http://:8090/":             false,
+		"http://localhost:8090/":    false,
+		"http://127.0.0.1:8090/":    false,
+		"http://192.168.1.2:8090/":  false,
+		"http://[::1]:8090/":        false,
+		"ftp://8.8.8.8/":            false,
+	}
+	for raw, ok := range cases {
+		if err := publicURL(raw); (err == nil) != ok {
+			t.Errorf("publicURL(%q) = %v, want ok %v", raw, err, ok)
+		}
+	}
+}
+
+func TestDetectOrigin(t *testing.T) {
+	cases := []struct {
+		body string
+		want string
+	}{
+		{"8.8.8.8\n", "8.8.8.8"},
+		{`{"ip": "2001:4860::8888"}`, "2001:4860::8888"},
+		{`{"origin": "1.1.1.1"}`, "1.1.1.1"},
+		{"127.0.0.1", ""},
+		{"<html>blocked</html>", ""},
+	}
+	for _, c := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, c.body)
+		}))
+		v := &validator{originUrl: srv.URL, timeout: time.Second}
+		got, err := v.detectOrigin(context.Background())
+		srv.Close()
+		if got != c.want || (err == nil) != (len(c.want) != 0) {
+			t.Errorf("echo %q: got %q, %v, want %q", c.body, got, err, c.want)
+		}
+	}
+}
+
+func TestClassifyAnonymity(t *testing.T) {
+	origin := "8.8.8.8"
+	cases := []struct {
+		remote  string
+		headers http.Header
+		want    string
+	}{
+		{"8.8.8.8", nil, public.AnonymityTransparent},
+		{"1.1.1.1", http.Header{"X-Forwarded-For": {"8.8.8.8, 1.1.1.1"}}, public.AnonymityTransparent},
+		{"1.1.1.1", http.Header{"Forwarded": {`for="[::ffff:8.8.8.8]:1234"`}}, public.AnonymityTransparent},
+		{"1.1.1.1", http.Header{"Via": {"1.1 squid"}}, public.AnonymityAnonymous},
+		{"1.1.1.1", http.Header{"Accept": {"*/*"}}, public.AnonymityElite},
+	}
+	for _, c := range cases {
+		report := &judgeReport{RemoteAddr: c.remote, Headers: c.headers}
+		if report.Headers == nil {
+			report.Headers = http.Header{}
+		}
+		if got := classifyAnonymity(origin, report); got != c.want {
+			t.Errorf("%s %v: got %s, want %s", c.remote, c.headers, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"hash/fnv"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// networkTypes are checked in this order when an ASN is listed under several of them.
+var networkTypes = []string{public.NetworkTypeDatacenter, public.NetworkTypeMobile, public.NetworkTypeResidential}
+
+type typedNetwork struct {
+	network     *net.IPNet
+	networkType string
+}
+
+// networkClassifier labels addresses with the network type of the most specific listed CIDR,
+// or else of their ASN. The version changes with the content of the lists.
+type networkClassifier struct {
+	networks []typedNetwork
+	asns     map[uint]string
+	version  string
+}
+
+// newNetworkClassifier reads classifier.<type>.cidr_files and classifier.<type>.asn_files,
+// it returns nil when there's no list.
+func newNetworkClassifier() (*networkClassifier, error) {
+	c := &networkClassifier{
+		networks: make([]typedNetwork, 0),
+		asns:     make(map[uint]string),
+	}
+	hash := fnv.New64a()
+	files := 0
+	for _, typ := range networkTypes {
+		for _, file := range viper.GetStringSlice("classifier." + typ + ".cidr_files") {
+			body, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			hash.Write(body)
+			files++
+			for i, line := range listLines(body) {
+				if !strings.Contains(line, "/") {
+					if ip := net.ParseIP(line); ip != nil && ip.To4() != nil {
+						line += "/32"
+					} else if ip != nil {
+						line += "/128"
+					}
+				}
+				_, network, err := net.ParseCIDR(line)
+				if err != nil {
+					return nil, fmt.Errorf("bad cidr %q in %s, line %d", line, file, i+1)
+				}
+				c.networks = append(c.networks, typedNetwork{network: network, networkType: typ})
+			}
+		}
+		for _, file := range viper.GetStringSlice("classifier." + typ + ".asn_files") {
+			body, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			hash.Write(body)
+			files++
+			for i, line := range listLines(body) {
+				asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(line), "AS"), 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("bad asn %q in %s, line %d", line, file, i+1)
+				}
+				if _, ok := c.asns[uint(asn)]; !ok {
+					c.asns[uint(asn)] = typ
+				}
+			}
+		}
+	}
+	if files == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(c.networks, func(i, j int) bool {
+		a, _ := c.networks[i].network.Mask.Size()
+		b, _ := c.networks[j].network.Mask.Size()
+		return a > b
+	})
+	c.version = strconv.FormatUint(hash.Sum64(), 16)
+	logrus.WithFields(logrus.Fields{
+		"networks": len(c.networks),
+		"asns":     len(c.asns),
+	}).Info("network lists loaded")
+	return c, nil
+}
+
+// listLines returns the lines of a list without blanks and comments, anything after
+// the first space, comma or # of a line is a comment too.
+func listLines(body []byte) []string {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexAny(line, " \t,#"); i >= 0 {
+			line = line[:i]
+		}
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (c *networkClassifier) classify(ip net.IP, asn uint) string {
+	if ip != nil {
+		for _, n := range c.networks {
+			if n.network.Contains(ip) {
+				return n.networkType
+			}
+		}
+	}
+	if typ, ok := c.asns[asn]; ok && asn != 0 {
+		return typ
+	}
+	return public.NetworkTypeUnknown
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	RejectFormat      = "format"
+	RejectPort        = "port"
+	RejectDialType    = "dial_type"
+	RejectUnspecified = "unspecified"
+	RejectLoopback    = "loopback"
+	RejectPrivate     = "private"
+	RejectLinkLocal   = "link_local"
+	RejectMulticast   = "multicast"
+	RejectReserved    = "reserved"
+	RejectDuplicate   = "duplicate"
+)
+
+// reservedPrefixes aren't routable on the internet, private, loopback, link local and
+// multicast ranges are told apart by netip.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("3fff::/20"),
+}
+
+// globalUnicast6 holds every ipv6 address allocated for use on the internet.
+var globalUnicast6 = netip.MustParsePrefix("2000::/3")
+
+// normalizeProxies returns the proxies with a valid dial type and a public ip:port address
+// in canonical form, every other one is counted in rejects by reason.
+func normalizeProxies(proxies []*Proxy) ([]*Proxy, map[string]int) {
+	valid := make([]*Proxy, 0, len(proxies))
+	rejects := make(map[string]int)
+	seen := make(map[string]bool, len(proxies))
+	for _, pxy := range proxies {
+		reason := normalizeProxy(pxy)
+		if len(reason) == 0 {
+			key := pxy.Address + "|" + string(pxy.DialType)
+			if seen[key] {
+				reason = RejectDuplicate
+			}
+			seen[key] = true
+		}
+		if len(reason) != 0 {
+			rejects[reason]++
+			continue
+		}
+		valid = append(valid, pxy)
+	}
+	return valid, rejects
+}
+
+// normalizeProxy rewrites the address of pxy like 1.2.3.4:80 or [2001:db8::1]:80, ipv4-mapped
+// ipv6 addresses become ipv4. It returns why pxy is rejected or nothing.
+func normalizeProxy(pxy *Proxy) string {
+	if !pxy.DialType.Valid() {
+		return RejectDialType
+	}
+	host, port, err := net.SplitHostPort(strings.TrimSpace(pxy.Address))
+	if err != nil {
+		return RejectFormat
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return RejectPort
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || addr.Zone() != "" {
+		return RejectFormat
+	}
+	addr = addr.Unmap()
+	if reason := rejectAddr(addr); len(reason) != 0 {
+		return reason
+	}
+	pxy.Address = netip.AddrPortFrom(addr, uint16(n)).String()
+	pxy.Username = strings.TrimSpace(pxy.Username)
+	pxy.Password = strings.TrimSpace(pxy.Password)
+	return ""
+}
+
+func rejectAddr(addr netip.Addr) string {
+	switch {
+	case addr.IsUnspecified():
+		return RejectUnspecified
+	case addr.IsLoopback():
+		return RejectLoopback
+	case addr.IsPrivate():
+		return RejectPrivate
+	case addr.IsLinkLocalUnicast():
+		return RejectLinkLocal
+	case addr.IsMulticast(), addr.IsLinkLocalMulticast(), addr.IsInterfaceLocalMulticast():
+		return RejectMulticast
+	}
+	if addr.Is6() && !globalUnicast6.Contains(addr) {
+		return RejectReserved
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return RejectReserved
+		}
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func TestNormalizeProxy(t *testing.T) {
+	cases := []struct {
+		address  string
+		dialType public.DialType
+		want     string
+		reason   string
+	}{
+		{address: "1.2.3.4:80", want: "1.2.3.4:80"},
+		{address: " 1.2.3.4:8080\r", want: "1.2.3.4:8080"},
+		{address: "1.2.3.4\r:80", reason: RejectFormat},
+		{address: "1.2.3.4:80\r\n5.6.7.8:80", reason: RejectFormat},
+		{address: "1.2.3.4", reason: RejectFormat},
+		{address: "proxy.example.com:80", reason: RejectFormat},
+		{address: "1.2.3.4:0", reason: RejectPort},
+		{address: "1.2.3.4:99999", reason: RejectPort},
+		{address: "1.2.3.4:http", reason: RejectPort},
+		{address: "1.2.3.4:80", dialType: "ftp", reason: RejectDialType},
+		{address: "0.0.0.0:80", reason: RejectUnspecified},
+		{address: "127.0.0.1:80", reason: RejectLoopback},
+		{address: "127.8.8.8:80", reason: RejectLoopback},
+		{address: "10.0.0.1:80", reason: RejectPrivate},
+		{address: "10.255.255.254:80", reason: RejectPrivate},
+		{address: "172.16.0.1:80", reason: RejectPrivate},
+		{address: "192.168.1.1:80", reason: RejectPrivate},
+		{address: "169.254.1.1:80", reason: RejectLinkLocal},
+		{address: "224.0.0.1:80", reason: RejectMulticast},
+		{address: "239.255.255.250:1900", reason: RejectMulticast},
+		{address: "0.1.2.3:80", reason: RejectReserved},
+		{address: "100.64.0.1:80", reason: RejectReserved},
+		{address: "192.0.0.8:80", reason: RejectReserved},
+		{address: "192.0.2.1:80", reason: RejectReserved},
+		{address: "198.18.0.1:80", reason: RejectReserved},
+		{address: "198.51.100.1:80", reason: RejectReserved},
+		{address: "203.0.113.7:80", reason: RejectReserved},
+		{address: "240.0.0.1:80", reason: RejectReserved},
+		{address: "255.255.255.255:80", reason: RejectReserved},
+		{address: "[2606:4700::1111]:443", want: "[2606:4700::1111]:443"},
+		{address: "[2606:4700:0:0:0:0:0:1111]:443", want: "[2606:4700::1111]:443"},
+		{address: "[2606:4700::ABCD]:443", want: "[2606:4700::abcd]:443"},
+		{address: "[::ffff:1.2.3.4]:80", want: "1.2.3.4:80"},
+		{address: "[::ffff:10.0.0.1]:80", reason: RejectPrivate},
+		{address: "2606:4700::1111", reason: RejectFormat},
+		{address: "[2606:4700::1111]", reason: RejectFormat},
+		{address: "[fe80::1%eth0]:80", reason: RejectFormat},
+		{address: "[::]:80", reason: RejectUnspecified},
+		{address: "[::1]:80", reason: RejectLoopback},
+		{address: "[fc00::1]:80", reason: RejectPrivate},
+		{address: "[fe80::1]:80", reason: RejectLinkLocal},
+		{address: "[ff02::1]:80", reason: RejectMulticast},
+		{address: "[2001:db8::1]:8080", reason: RejectReserved},
+		{address: "[64:ff9b::1.2.3.4]:80", reason: RejectReserved},
+	}
+	for _, c := range cases {
+		if len(c.dialType) == 0 {
+			c.dialType = public.DialTypeHttp
+		}
+		pxy := &Proxy{Address: c.address, DialType: c.dialType}
+		reason := normalizeProxy(pxy)
+		if reason != c.reason {
+			t.Errorf("normalizeProxy(%q) rejected as %q, want %q", c.address, reason, c.reason)
+			continue
+		}
+		if len(reason) == 0 && pxy.Address != c.want {
+			t.Errorf("normalizeProxy(%q) = %q, want %q", c.address, pxy.Address, c.want)
+		}
+	}
+}
+
+func TestNormalizeProxies(t *testing.T) {
+	proxies := []*Proxy{
+		{Address: "1.2.3.4:80", DialType: public.DialTypeHttp},
+		{Address: " 1.2.3.4:80 ", DialType: public.DialTypeHttp},
+		{Address: "1.2.3.4:80", DialType: public.DialTypeSocks5},
+		{Address: "5.6.7.8:1080", DialType: public.DialTypeSocks5},
+		{Address: "5.6.7.8:1080", DialType: public.DialTypeSocks5},
+		{Address: "1.2.3.4:0", DialType: public.DialTypeHttp},
+		{Address: "1.2.3.4:99999", DialType: public.DialTypeHttp},
+		{Address: "10.0.0.1:80", DialType: public.DialTypeHttp},
+		{Address: "127.0.0.1:80", DialType: public.DialTypeHttp},
+		{Address: "0.0.0.0:80", DialType: public.DialTypeHttp},
+		{Address: "224.0.0.1:80", DialType: public.DialTypeHttp},
+		{Address: "192.0.2.1:80", DialType: public.DialTypeHttp},
+		{Address: "198.51.100.1:80", DialType: public.DialTypeHttp},
+		{Address: "garbage", DialType: public.DialTypeHttp},
+		{Address: "1.2.3.4:80", DialType: "ftp"},
+	}
+	valid, rejects := normalizeProxies(proxies)
+	addresses := make([]string, 0, len(valid))
+	for _, pxy := range valid {
+		addresses = append(addresses, pxy.Address+" "+string(pxy.DialType))
+	}
+	if want := "[1.2.3.4:80 http 1.2.3.4:80 socks5 5.6.7.8:1080 socks5]"; fmt.Sprint(addresses) != want {
+		t.Errorf("got %v, want %s", addresses, want)
+	}
+	want := map[string]int{
+		RejectDuplicate:   2,
+		RejectPort:        2,
+		RejectPrivate:     1,
+		RejectLoopback:    1,
+		RejectUnspecified: 1,
+		RejectMulticast:   1,
+		RejectReserved:    2,
+		RejectFormat:      1,
+		RejectDialType:    1,
+	}
+	if fmt.Sprint(rejects) != fmt.Sprint(want) {
+		t.Errorf("got rejects %v, want %v", rejects, want)
+	}
+}
+
+// The longest normalized address has to fit the address column.
+func TestNormalizedAddressSize(t *testing.T) {
+	pxy := &Proxy{Address: "[2606:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", DialType: public.DialTypeHttp}
+	if reason := normalizeProxy(pxy); len(reason) != 0 {
+		t.Fatalf("rejected as %s", reason)
+	}
+	if len(pxy.Address) > addressSize {
+		t.Fatalf("%s is %d chars, the column holds %d", pxy.Address, len(pxy.Address), addressSize)
+	}
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"io"
+	"strings"
+)
+
+const (
+	formatPlain  = "plain"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+type ndjsonProxy struct {
+	Address   string      `json:"address"`
+	Host      string      `json:"host"`
+	Ip        string      `json:"ip"`
+	Port      interface{} `json:"port"`
+	Username  string      `json:"username"`
+	Password  string      `json:"password"`
+	Protocol  string      `json:"protocol"`
+	DialType  string      `json:"dial_type"`
+	Country   string      `json:"country"`
+	Anonymity string      `json:"anonymity"`
+}
+
+func validFormat(format string) bool {
+	return format == formatPlain || format == formatNDJSON || format == formatCSV
+}
+
+func parseProxies(body []byte, format, provider string, dialType public.DialType) ([]*Proxy, error) {
+	switch format {
+	case formatPlain, "":
+		return parsePlain(body, provider, dialType), nil
+	case formatNDJSON:
+		return parseNDJSON(body, provider, dialType)
+	case formatCSV:
+		return parseCSV(body, provider, dialType)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func parsePlain(body []byte, provider string, dialType public.DialType) []*Proxy {
+	proxies := make([]*Proxy, 0)
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		proxies = append(proxies, newProxy(string(line), provider, dialType))
+	}
+	return proxies
+}
+
+func parseNDJSON(body []byte, provider string, dialType public.DialType) ([]*Proxy, error) {
+	proxies := make([]*Proxy, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		item := &ndjsonProxy{}
+		if err := json.Unmarshal(line, item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if p := item.toProxy(provider, dialType); p != nil {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies, scanner.Err()
+}
+
+// parseCSV reads csv with a header row, columns share the names of ndjson fields.
+func parseCSV(body []byte, provider string, dialType public.DialType) ([]*Proxy, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	get := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	proxies := make([]*Proxy, 0)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return proxies, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		item := &ndjsonProxy{
+			Address:   get(record, "address"),
+			Host:      get(record, "host"),
+			Ip:        get(record, "ip"),
+			Port:      get(record, "port"),
+			Username:  get(record, "username"),
+			Password:  get(record, "password"),
+			Protocol:  get(record, "protocol"),
+			DialType:  get(record, "dial_type"),
+			Country:   get(record, "country"),
+			Anonymity: get(record, "anonymity"),
+		}
+		if p := item.toProxy(provider, dialType); p != nil {
+			proxies = append(proxies, p)
+		}
+	}
+}
+
+func (item *ndjsonProxy) toProxy(provider string, dialType public.DialType) *Proxy {
+	address := item.Address
+	if len(address) == 0 {
+		host := item.Host
+		if len(host) == 0 {
+			host = item.Ip
+		}
+		address = host
+		if port := jsonString(item.Port); len(port) != 0 {
+			address = joinHostPort(host, port)
+		}
+	}
+	if len(address) == 0 {
+		return nil
+	}
+	protocol := item.DialType
+	if len(protocol) == 0 {
+		protocol = item.Protocol
+	}
+	p := newProxy(address, provider, mapDialType(protocol, nil, dialType))
+	if len(item.Username) != 0 {
+		p.Username, p.Password = item.Username, item.Password
+	}
+	p.Country = item.Country
+	p.Anonymity = normalizeAnonymity(item.Anonymity)
+	return p
+}
+
+// mapDialType maps the protocol a provider lists to a dial type, protocolMap first. Providers
+// listing "https" mean http proxies able to CONNECT to https sites, so it's http unless
+// protocolMap says otherwise.
+func mapDialType(protocol string, protocolMap map[string]public.DialType, fallback public.DialType) public.DialType {
+	protocol = strings.ToLower(strings.TrimSpace(protocol))
+	if typ, ok := protocolMap[protocol]; ok {
+		return typ
+	}
+	switch {
+	case strings.Contains(protocol, "socks5h"):
+		return public.DialTypeSocks5h
+	case strings.Contains(protocol, "socks5"):
+		return public.DialTypeSocks5
+	case strings.Contains(protocol, "socks4a"):
+		return public.DialTypeSocks4a
+	case strings.Contains(protocol, "socks4"):
+		return public.DialTypeSocks4
+	case strings.Contains(protocol, "http"):
+		return public.DialTypeHttp
+	default:
+		return fallback
+	}
+}
+
+func validDialTypes(dialType public.DialType, protocolMap map[string]public.DialType) error {
+	if !dialType.Valid() {
+		return fmt.Errorf("unknown dial type %q", dialType)
+	}
+	for protocol, typ := range protocolMap {
+		if !typ.Valid() {
+			return fmt.Errorf("unknown dial type %q for protocol %q", typ, protocol)
+		}
+	}
+	return nil
+}
+
+// maybeGunzip decompresses body when it starts with the gzip magic number.
+func maybeGunzip(body []byte) ([]byte, error) {
+	if len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		return body, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
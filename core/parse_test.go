@@ -0,0 +1,35 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func TestMapDialType(t *testing.T) {
+	tlsMap := map[string]public.DialType{"https": public.DialTypeHttps, "s4": public.DialTypeSocks4}
+	cases := []struct {
+		protocol    string
+		protocolMap map[string]public.DialType
+		want        public.DialType
+	}{
+		{"http", nil, public.DialTypeHttp},
+		{"HTTPS", nil, public.DialTypeHttp},
+		{" https ", nil, public.DialTypeHttp},
+		{"http/https", nil, public.DialTypeHttp},
+		{"https", tlsMap, public.DialTypeHttps},
+		{"s4", tlsMap, public.DialTypeSocks4},
+		{"socks4", nil, public.DialTypeSocks4},
+		{"socks4a", nil, public.DialTypeSocks4a},
+		{"SOCKS5", nil, public.DialTypeSocks5},
+		{"socks5h", nil, public.DialTypeSocks5h},
+		{"socks5 elite", nil, public.DialTypeSocks5},
+		{"", nil, public.DialTypeSocks4},
+		{"ftp", nil, public.DialTypeSocks4},
+	}
+	for _, c := range cases {
+		if got := mapDialType(c.protocol, c.protocolMap, public.DialTypeSocks4); got != c.want {
+			t.Errorf("mapDialType(%q, %v) = %s, want %s", c.protocol, c.protocolMap, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+	"gorm.io/gorm"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	poolPickSize   = 20
+	poolFailureTTL = 30 * time.Minute
+)
+
+// bootstrapDialTypes are the dial types fasthttp can fetch through.
+var bootstrapDialTypes = []public.DialType{public.DialTypeHttp, public.DialTypeSocks5, public.DialTypeSocks5h}
+
+// proxyPool hands out proxies of the proxy table to executors fetching through the pool.
+// Proxies failing a fetch aren't handed out again for a while.
+type proxyPool struct {
+	database *gorm.DB
+	failed   map[string]time.Time
+	mu       sync.Mutex
+}
+
+func newProxyPool(db *gorm.DB) *proxyPool {
+	return &proxyPool{
+		database: db,
+		failed:   make(map[string]time.Time),
+	}
+}
+
+// pick returns a random proxy of dialTypes found alive by the validator within maxAge,
+// only on networkTypes unless it's empty.
+func (p *proxyPool) pick(ctx context.Context, dialTypes []public.DialType, networkTypes []string, maxAge time.Duration) (*Proxy, error) {
+	candidates := make([]*Proxy, 0, poolPickSize)
+	query := p.database.WithContext(ctx).
+		Where("alive = ? and checked_at >= ? and dial_type in ?", true, time.Now().Add(-maxAge).Unix(), dialTypes)
+	if len(networkTypes) != 0 {
+		query = query.Where("network_type in ?", networkTypes)
+	}
+	if err := query.
+		Order("rand()").
+		Limit(poolPickSize).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, at := range p.failed {
+		if time.Since(at) > poolFailureTTL {
+			delete(p.failed, key)
+		}
+	}
+	for _, pxy := range candidates {
+		if _, ok := p.failed[pxy.URL()]; !ok {
+			return pxy, nil
+		}
+	}
+	return nil, errors.New("no usable proxy in the pool")
+}
+
+func (p *proxyPool) markFailed(pxy *Proxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failed[pxy.URL()] = time.Now()
+}
+
+// bootstrap routes the requests of one httpFetcher through pool proxies. It sticks to
+// a proxy while it works and rotates to another one once it fails.
+type bootstrap struct {
+	pool         *proxyPool
+	dialTypes    []public.DialType
+	networkTypes []string
+	maxAge       time.Duration
+	rotate       int
+	timeout      time.Duration
+	proxy        *Proxy
+	client       *fasthttp.Client
+	mu           sync.Mutex
+}
+
+// newBootstrap reads <key>.bootstrap, it returns nil when fetching through the pool isn't enabled.
+func newBootstrap(key string, pool *proxyPool, timeout time.Duration) (*bootstrap, error) {
+	key += ".bootstrap"
+	if !viper.GetBool(key + ".enabled") {
+		return nil, nil
+	}
+	b := &bootstrap{
+		pool:         pool,
+		dialTypes:    bootstrapDialTypes,
+		networkTypes: viper.GetStringSlice(key + ".network_types"),
+		maxAge:       viper.GetDuration(key + ".max_age"),
+		rotate:       viper.GetInt(key + ".rotate"),
+		timeout:      timeout,
+	}
+	if names := viper.GetStringSlice(key + ".dial_types"); len(names) != 0 {
+		b.dialTypes = make([]public.DialType, 0, len(names))
+		for _, name := range names {
+			dt, err := public.ParseDialType(name)
+			if err != nil || !containsDialType(bootstrapDialTypes, dt) {
+				return nil, fmt.Errorf("can't fetch through %q proxies", name)
+			}
+			b.dialTypes = append(b.dialTypes, dt)
+		}
+	}
+	if b.maxAge <= 0 {
+		b.maxAge = 30 * time.Minute
+	}
+	if b.rotate < 1 {
+		b.rotate = 3
+	}
+	return b, nil
+}
+
+// do sends req through the current pool proxy. A proxy failing with a network error, 403, 407,
+// 429 or 5xx is dropped for the next one, at most rotate proxies are tried.
+func (b *bootstrap) do(ctx context.Context, req *fasthttp.Request, res *fasthttp.Response,
+	send func(context.Context, *fasthttp.Client, *fasthttp.Request, *fasthttp.Response) error) error {
+	var err error
+	for i := 0; i < b.rotate; i++ {
+		pxy, client, perr := b.current(ctx)
+		if perr != nil {
+			if err == nil {
+				err = perr
+			}
+			return err
+		}
+		err = send(ctx, client, req, res)
+		if ctx.Err() != nil {
+			return err
+		}
+		if err == nil && !rotateStatus(res.StatusCode()) {
+			return nil
+		}
+
+		entry := logrus.WithFields(logrus.Fields{
+			"url":   string(req.RequestURI()),
+			"proxy": pxy.Address,
+		})
+		if err != nil {
+			entry = entry.WithError(err)
+		} else {
+			entry = entry.WithField("status", res.StatusCode())
+		}
+		entry.Warn("pool proxy failed, rotating")
+		b.drop(pxy)
+		if i+1 < b.rotate {
+			res.Reset()
+		}
+	}
+	return err
+}
+
+func rotateStatus(code int) bool {
+	return code == fasthttp.StatusForbidden || code == fasthttp.StatusProxyAuthRequired || retryableStatus(code)
+}
+
+func (b *bootstrap) current(ctx context.Context) (*Proxy, *fasthttp.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.proxy != nil {
+		return b.proxy, b.client, nil
+	}
+	pxy, err := b.pool.pick(ctx, b.dialTypes, b.networkTypes, b.maxAge)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := url.Parse(pxy.URL())
+	if err != nil {
+		return nil, nil, err
+	}
+	b.proxy = pxy
+	b.client = newUpstreamClient(u, b.timeout)
+	logrus.WithFields(logrus.Fields{
+		"proxy":     pxy.Address,
+		"dial_type": pxy.DialType,
+	}).Info("fetching through pool proxy")
+	return b.proxy, b.client, nil
+}
+
+func (b *bootstrap) drop(pxy *Proxy) {
+	b.pool.markFailed(pxy)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.proxy == pxy {
+		b.client.CloseIdleConnections()
+		b.proxy, b.client = nil, nil
+	}
+}
+
+func (b *bootstrap) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		b.client.CloseIdleConnections()
+	}
+}
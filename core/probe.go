@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// probe is one way of talking to a proxy, forward sends plain requests instead of tunneling them.
+type probe struct {
+	name     string
+	dialType public.DialType
+	forward  bool
+}
+
+var probes = []probe{
+	{name: "http connect", dialType: public.DialTypeHttp},
+	{name: "http forward", dialType: public.DialTypeHttp, forward: true},
+	{name: "socks4", dialType: public.DialTypeSocks4},
+	{name: "socks5", dialType: public.DialTypeSocks5},
+}
+
+// probeResult is a probe that worked.
+type probeResult struct {
+	dialType public.DialType
+	forward  bool
+	latency  time.Duration
+}
+
+// probeProtocols requests the probe target through the address of pxy with every probe and
+// returns the ones that worked, http connect and http forward are separate results.
+func (v *validator) probeProtocols(ctx context.Context, pxy *Proxy) []probeResult {
+	found := make([]probeResult, 0, len(probes))
+	for _, p := range probes {
+		if ctx.Err() != nil {
+			break
+		}
+		candidate := *pxy
+		candidate.DialType = p.dialType
+		start := time.Now()
+		if _, err := v.get(ctx, proxyTransport(&candidate, p.forward), v.probeTarget); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"address": pxy.Address,
+				"probe":   p.name,
+			}).Debug("probe failed")
+			continue
+		}
+		found = append(found, probeResult{dialType: p.dialType, forward: p.forward, latency: time.Since(start)})
+	}
+	return found
+}
+
+// probedDialTypes merges the results by dial type, forward is set when the address forwards
+// plain http requests.
+func probedDialTypes(found []probeResult) (map[public.DialType]probeResult, bool) {
+	merged := make(map[public.DialType]probeResult, len(found))
+	forward := false
+	for _, r := range found {
+		forward = forward || r.forward
+		if _, ok := merged[r.dialType]; !ok || !r.forward {
+			merged[r.dialType] = r
+		}
+	}
+	return merged, forward
+}
+
+// addProtocols stores a row for every dial type in found that the address of pxy isn't stored with yet,
+// and marks its http rows when plain forwarding worked. Variants like socks5h count as their base
+// dial type. New rows take the check state of pxy, which has been checked since the probe, so
+// they aren't probed again.
+func (v *validator) addProtocols(pxy *Proxy, found []probeResult) {
+	merged, forward := probedDialTypes(found)
+	if forward {
+		if err := v.database.Model(&Proxy{}).Where("address = ? and dial_type in ?", pxy.Address,
+			[]public.DialType{public.DialTypeHttp, public.DialTypeHttps}).Update("forward", true).Error; err != nil {
+			logrus.WithError(err).WithField("address", pxy.Address).Error("failed to mark forwarding proxy")
+		}
+	}
+	now := time.Now()
+	checkedAt, nextCheckAt := pxy.CheckedAt, pxy.NextCheckAt
+	// pxy has been purged
+	if checkedAt == 0 {
+		checkedAt, nextCheckAt = now.Unix(), now.Add(v.recheckMin).Unix()
+	}
+	for _, dt := range []public.DialType{public.DialTypeHttp, public.DialTypeSocks4, public.DialTypeSocks5} {
+		r, ok := merged[dt]
+		if !ok || baseDialType(pxy.DialType) == dt {
+			continue
+		}
+		existing := make([]*Proxy, 0, 1)
+		if err := v.database.Where("address = ? and dial_type in ?", pxy.Address, dialTypeVariants(dt)).
+			Limit(1).Find(&existing).Error; err != nil {
+			logrus.WithError(err).WithField("address", pxy.Address).Error("failed to look up probed proxy")
+			continue
+		}
+		if len(existing) != 0 {
+			continue
+		}
+		row := &Proxy{
+			Address:       pxy.Address,
+			Username:      pxy.Username,
+			Password:      pxy.Password,
+			Provider:      pxy.Provider,
+			DialType:      dt,
+			Country:       pxy.Country,
+			Anonymity:     pxy.Anonymity,
+			City:          pxy.City,
+			ASN:           pxy.ASN,
+			Org:           pxy.Org,
+			NetworkType:   pxy.NetworkType,
+			EnrichVersion: pxy.EnrichVersion,
+			Forward:       dt == public.DialTypeHttp && forward,
+			Alive:         true,
+			Latency:       r.latency.Milliseconds(),
+			CheckedAt:     checkedAt,
+			NextCheckAt:   nextCheckAt,
+			CreatedAt:     now.Unix(),
+			UpdatedAt:     now.Unix(),
+		}
+		if v.filter != nil {
+			if rule := v.filter.check(row); len(rule) != 0 {
+				logrus.WithFields(logrus.Fields{
+					"address": pxy.Address,
+					"found":   dt,
+					"rule":    rule,
+				}).Debug("probed protocol filtered")
+				continue
+			}
+		}
+		if err := v.database.Create(row).Error; err != nil {
+			logrus.WithError(err).WithField("address", pxy.Address).Error("failed to save probed proxy")
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"address":  pxy.Address,
+			"provider": pxy.Provider,
+			"labeled":  pxy.DialType,
+			"found":    dt,
+			"forward":  row.Forward,
+		}).Info("proxy also speaks another protocol")
+	}
+}
+
+func baseDialType(dt public.DialType) public.DialType {
+	switch dt {
+	case public.DialTypeSocks4a:
+		return public.DialTypeSocks4
+	case public.DialTypeSocks5h:
+		return public.DialTypeSocks5
+	default:
+		return dt
+	}
+}
+
+func dialTypeVariants(dt public.DialType) []public.DialType {
+	switch dt {
+	case public.DialTypeSocks4:
+		return []public.DialType{public.DialTypeSocks4, public.DialTypeSocks4a}
+	case public.DialTypeSocks5:
+		return []public.DialType{public.DialTypeSocks5, public.DialTypeSocks5h}
+	default:
+		return []public.DialType{dt}
+	}
+}
+
+func containsDialType(types []public.DialType, dt public.DialType) bool {
+	for _, t := range types {
+		if t == dt {
+			return true
+		}
+	}
+	return false
+}
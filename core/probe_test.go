@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func TestProbeProtocols(t *testing.T) {
+	target := newTestTarget(t)
+	cases := []struct {
+		name    string
+		proxy   *testProxy
+		want    []string
+		forward bool
+	}{
+		{"connect", startTestProxy(t, serveHTTPProxy(true, false)), []string{"http"}, false},
+		{"connect and forward", startTestProxy(t, serveHTTPProxy(true, true)), []string{"http", "http forward"}, true},
+		{"forward only", startTestProxy(t, serveHTTPProxy(false, true)), []string{"http forward"}, true},
+		{"socks4", startTestProxy(t, serveSocks4("")), []string{"socks4"}, false},
+		{"socks5", startTestProxy(t, serveSocks5("", "")), []string{"socks5"}, false},
+		{"silent", startTestProxy(t, serveSilent), []string{}, false},
+	}
+	v := &validator{probeTarget: target.URL, target: target.URL, timeout: 500 * time.Millisecond}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pxy := &Proxy{Address: c.proxy.addr(), DialType: public.DialTypeSocks5h}
+			found := v.probeProtocols(context.Background(), pxy)
+			names := make([]string, 0, len(found))
+			for _, r := range found {
+				name := string(r.dialType)
+				if r.forward {
+					name += " forward"
+				}
+				names = append(names, name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(c.want) {
+				t.Fatalf("found %v, want %v", names, c.want)
+			}
+			merged, forward := probedDialTypes(found)
+			if forward != c.forward {
+				t.Fatalf("forward %v, want %v", forward, c.forward)
+			}
+			// a row stored for each probed dial type passes its checks
+			for dt := range merged {
+				candidate := *pxy
+				candidate.DialType = dt
+				candidate.Forward = dt == public.DialTypeHttp && forward
+				if r := v.check(context.Background(), &candidate); !r.alive {
+					t.Fatalf("probed %s fails the check: %v", dt, r.err)
+				}
+			}
+		})
+	}
+}
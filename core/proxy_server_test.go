@@ -0,0 +1,212 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// testProxy is a minimal local proxy speaking http CONNECT, plain http forwarding, socks4 or socks5.
+type testProxy struct {
+	listener net.Listener
+}
+
+func startTestProxy(t *testing.T, serve func(p *testProxy, conn net.Conn)) *testProxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProxy{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(p, conn)
+			}()
+		}
+	}()
+	t.Cleanup(p.close)
+	return p
+}
+
+func (p *testProxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *testProxy) close() {
+	p.listener.Close()
+}
+
+// pipe copies between the client and the upstream connection until one side closes.
+func pipe(client, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, client)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// serveConnect tunnels CONNECT requests, with forward set it also sends plain requests on.
+func serveConnect(forward bool) func(p *testProxy, conn net.Conn) {
+	return serveHTTPProxy(true, forward)
+}
+
+// serveHTTPProxy tunnels CONNECT requests when connect is set and sends plain requests on
+// when forward is set.
+func serveHTTPProxy(connect, forward bool) func(p *testProxy, conn net.Conn) {
+	return func(p *testProxy, conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect {
+			if !forward {
+				io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 0\r\n\r\n")
+				return
+			}
+			req.RequestURI = ""
+			res, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+				return
+			}
+			defer res.Body.Close()
+			res.Write(conn)
+			return
+		}
+		if !connect {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer upstream.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(conn, upstream)
+	}
+}
+
+// serveSocks5 tunnels socks5 connect requests, credentials are required when username is set.
+func serveSocks5(username, password string) func(p *testProxy, conn net.Conn) {
+	return func(p *testProxy, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(r, head); err != nil || head[0] != 5 {
+			return
+		}
+		methods := make([]byte, head[1])
+		if _, err := io.ReadFull(r, methods); err != nil {
+			return
+		}
+		if len(username) == 0 {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+			ver, _ := r.ReadByte()
+			n, _ := r.ReadByte()
+			user := make([]byte, n)
+			io.ReadFull(r, user)
+			n, _ = r.ReadByte()
+			pass := make([]byte, n)
+			io.ReadFull(r, pass)
+			if ver != 1 || string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(r, req); err != nil || req[1] != 1 {
+			return
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 4:
+			ip := make([]byte, 16)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 3:
+			n, _ := r.ReadByte()
+			name := make([]byte, n)
+			io.ReadFull(r, name)
+			host = string(name)
+		default:
+			return
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(r, port); err != nil {
+			return
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		pipe(conn, upstream)
+	}
+}
+
+// serveSocks4 tunnels socks4 and socks4a connect requests, the user id has to match userID when it's set.
+func serveSocks4(userID string) func(p *testProxy, conn net.Conn) {
+	return func(p *testProxy, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(r, req); err != nil || req[0] != 4 || req[1] != 1 {
+			return
+		}
+		user, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		host := net.IP(req[4:8]).String()
+		// socks4a, 0.0.0.x is followed by the host name
+		if req[4] == 0 && req[5] == 0 && req[6] == 0 && req[7] != 0 {
+			name, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			host = name[:len(name)-1]
+		}
+		if len(userID) != 0 && user[:len(user)-1] != userID {
+			conn.Write([]byte{0, 0x5d, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(req[2:4])))))
+		if err != nil {
+			conn.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{0, 0x5a, 0, 0, 0, 0, 0, 0})
+		pipe(conn, upstream)
+	}
+}
+
+// serveSilent accepts connections and never answers.
+func serveSilent(p *testProxy, conn net.Conn) {
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	ErrCategoryNetwork  = "network"
+	ErrCategoryStatus   = "http_status"
+	ErrCategoryDecode   = "decode"
+	ErrCategoryParse    = "parse"
+	ErrCategoryEmpty    = "empty"
+	ErrCategoryCommand  = "command"
+	ErrCategoryCanceled = "canceled"
+)
+
+type FetchError struct {
+	Category   string
+	Url        string
+	StatusCode int
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	msg := e.Category
+	if len(e.Url) != 0 {
+		msg += " " + e.Url
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+func newFetchError(category, url string, err error) *FetchError {
+	return &FetchError{Category: category, Url: url, Err: err}
+}
+
+// requestError categorizes a failed request, a done ctx means the fetch was canceled rather than the provider being down.
+func requestError(ctx context.Context, url string, err error) *FetchError {
+	if ctx.Err() != nil {
+		return newFetchError(ErrCategoryCanceled, url, err)
+	}
+	return newFetchError(ErrCategoryNetwork, url, err)
+}
+
+// ErrorCategory returns the category of err, or an empty string when err isn't a FetchError.
+func ErrorCategory(err error) string {
+	fe := &FetchError{}
+	if errors.As(err, &fe) {
+		return fe.Category
+	}
+	return ""
+}
+
+// SourceResult describes one url, command or file set fetched by an executor.
+type SourceResult struct {
+	Name        string
+	Url         string
+	Count       int
+	Bytes       int
+	Duration    time.Duration
+	NotModified bool
+	Err         error
+}
+
+type FetchResult struct {
+	Proxies     []*Proxy
+	Err         error
+	Bytes       int
+	Duration    time.Duration
+	NotModified bool
+	Sources     []SourceResult
+}
+
+func (r *FetchResult) Category() string {
+	return ErrorCategory(r.Err)
+}
+
+type sourceFunc func(ctx context.Context) ([]*Proxy, SourceResult)
+
+// runSources fetches every source in turn. The result only carries an error when nothing
+// was fetched: every source failed, the fetch was canceled, or there were simply no proxies.
+func runSources(ctx context.Context, sources []sourceFunc) FetchResult {
+	start := time.Now()
+	result := FetchResult{
+		Proxies: make([]*Proxy, 0),
+		Sources: make([]SourceResult, 0, len(sources)),
+	}
+	failed := make([]error, 0)
+	notModified := 0
+	for _, fetch := range sources {
+		begin := time.Now()
+		proxies, sr := fetch(ctx)
+		sr.Count = len(proxies)
+		sr.Duration = time.Since(begin)
+		result.Proxies = append(result.Proxies, proxies...)
+		result.Bytes += sr.Bytes
+		result.Sources = append(result.Sources, sr)
+		if sr.Err != nil {
+			failed = append(failed, sr.Err)
+		}
+		if sr.NotModified {
+			notModified++
+		}
+	}
+	result.Duration = time.Since(start)
+	result.NotModified = len(sources) != 0 && notModified == len(sources)
+
+	switch {
+	case ctx.Err() != nil:
+		result.Err = newFetchError(ErrCategoryCanceled, "", ctx.Err())
+	case len(result.Proxies) != 0 || result.NotModified:
+	case len(failed) == 1:
+		result.Err = failed[0]
+	case len(failed) > 1:
+		msgs := make([]string, 0, len(failed))
+		for _, err := range failed {
+			msgs = append(msgs, err.Error())
+		}
+		result.Err = newFetchError(ErrorCategory(failed[0]), "", errors.New(strings.Join(msgs, "; ")))
+	default:
+		result.Err = newFetchError(ErrCategoryEmpty, "", errors.New("no proxy fetched"))
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"math/rand"
+	"time"
+)
+
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// newRetryPolicy reads <key>.retry.attempts, <key>.retry.base_delay and <key>.retry.max_delay,
+// without them a request is tried once.
+func newRetryPolicy(key string) retryPolicy {
+	r := retryPolicy{
+		attempts:  viper.GetInt(key + ".retry.attempts"),
+		baseDelay: viper.GetDuration(key + ".retry.base_delay"),
+		maxDelay:  viper.GetDuration(key + ".retry.max_delay"),
+	}
+	if r.attempts < 1 {
+		r.attempts = 1
+	}
+	if r.baseDelay <= 0 {
+		r.baseDelay = time.Second
+	}
+	if r.maxDelay <= 0 {
+		r.maxDelay = 30 * time.Second
+	}
+	return r
+}
+
+// backoff returns the delay before the given retry, the exponential delay is jittered between half and full.
+func (r retryPolicy) backoff(retry int) time.Duration {
+	delay := r.baseDelay << uint(retry-1)
+	if delay <= 0 || delay > r.maxDelay {
+		delay = r.maxDelay
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
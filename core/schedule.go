@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"math/rand"
+	"time"
+)
+
+type schedule struct {
+	interval time.Duration
+	cron     cron.Schedule
+	jitter   time.Duration
+}
+
+// newSchedule reads executor.<name>.cron, executor.<name>.interval and executor.<name>.jitter,
+// falling back to factory.fetch_interval and factory.jitter.
+func newSchedule(name string) (*schedule, error) {
+	key := "executor." + name
+	s := &schedule{}
+	jitter := viper.GetInt64(key + ".jitter")
+	if jitter == 0 {
+		jitter = viper.GetInt64("factory.jitter")
+	}
+	if jitter < 0 {
+		return nil, fmt.Errorf("negative jitter %d", jitter)
+	}
+	s.jitter = time.Duration(jitter) * time.Second
+
+	if expr := viper.GetString(key + ".cron"); len(expr) != 0 {
+		c, err := cron.ParseStandard(expr)
+		if err != nil {
+			return nil, fmt.Errorf("bad cron %q: %w", expr, err)
+		}
+		s.cron = c
+		return s, nil
+	}
+
+	interval := viper.GetInt64(key + ".interval")
+	if interval == 0 {
+		interval = viper.GetInt64("factory.fetch_interval")
+	}
+	if interval == 0 {
+		interval = 10
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("negative interval %d", interval)
+	}
+	s.interval = time.Duration(interval) * time.Second
+	return s, nil
+}
+
+func (s *schedule) next(now time.Time) time.Time {
+	var t time.Time
+	if s.cron != nil {
+		t = s.cron.Next(now)
+	} else {
+		t = now.Add(s.interval)
+	}
+	if s.jitter > 0 {
+		t = t.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	return t
+}
+
+func (s *schedule) String() string {
+	if s.cron != nil {
+		return fmt.Sprintf("cron with %s jitter", s.jitter)
+	}
+	return fmt.Sprintf("every %s with %s jitter", s.interval, s.jitter)
+}
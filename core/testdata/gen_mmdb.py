@@ -0,0 +1,127 @@
+#!/usr/bin/env python3
+"""Writes the small MaxMind databases used by the geo tests, run it from core/testdata."""
+import ipaddress
+
+
+def ctrl(typ, size):
+    extra = b""
+    if size >= 29:
+        size, extra = 29, bytes([size - 29])
+    if typ <= 7:
+        return bytes([typ << 5 | size]) + extra
+    return bytes([size, typ - 7]) + extra
+
+
+def string(v):
+    b = v.encode()
+    assert len(b) < 285
+    return ctrl(2, len(b)) + b
+
+
+def uint(typ, v, width):
+    b = v.to_bytes(width, "big").lstrip(b"\0")
+    return ctrl(typ, len(b)) + b
+
+
+def uint16(v):
+    return uint(5, v, 2)
+
+
+def uint32(v):
+    return uint(6, v, 4)
+
+
+def uint64(v):
+    return uint(9, v, 8)
+
+
+def mapping(d):
+    out = ctrl(7, len(d))
+    for k, v in d.items():
+        out += string(k) + v
+    return out
+
+
+def array(items):
+    return ctrl(11, len(items)) + b"".join(items)
+
+
+def build(path, dbtype, epoch, records):
+    """records maps networks to encoded data, ipv4 networks live in ::/96."""
+    nodes = [[None, None]]
+    data = b""
+    for network, value in records.items():
+        net = ipaddress.ip_network(network)
+        key, length = int(net.network_address), net.prefixlen
+        if net.version == 4:
+            length += 96
+        node = 0
+        for depth in range(length):
+            bit = key >> (127 - depth) & 1
+            if depth == length - 1:
+                nodes[node][bit] = ("data", len(data))
+                break
+            nxt = nodes[node][bit]
+            if nxt is None:
+                nodes.append([None, None])
+                nxt = ("node", len(nodes) - 1)
+                nodes[node][bit] = nxt
+            node = nxt[1]
+        data += value
+
+    count = len(nodes)
+
+    def record(r):
+        if r is None:
+            v = count
+        elif r[0] == "node":
+            v = r[1]
+        else:
+            v = count + 16 + r[1]
+        return v.to_bytes(3, "big")
+
+    tree = b"".join(record(l) + record(r) for l, r in nodes)
+    meta = mapping({
+        "node_count": uint32(count),
+        "record_size": uint16(24),
+        "ip_version": uint16(6),
+        "database_type": string(dbtype),
+        "languages": array([string("en")]),
+        "binary_format_major_version": uint16(2),
+        "binary_format_minor_version": uint16(0),
+        "build_epoch": uint64(epoch),
+        "description": mapping({"en": string("pxier fixture")}),
+    })
+    with open(path, "wb") as f:
+        f.write(tree + b"\0" * 16 + data + b"\xab\xcd\xefMaxMind.com" + meta)
+
+
+def city(country, name):
+    return mapping({
+        "country": mapping({"iso_code": string(country)}),
+        "city": mapping({"names": mapping({"en": string(name)})}),
+    })
+
+
+def asn(number, org):
+    return mapping({
+        "autonomous_system_number": uint32(number),
+        "autonomous_system_organization": string(org),
+    })
+
+
+build("city.mmdb", "GeoLite2-City", 1600000000, {
+    "1.1.1.0/24": city("AU", "Sydney"),
+    "8.8.8.0/24": city("US", "Mountain View"),
+    "2001:4860::/32": city("US", "Mountain View"),
+})
+build("city-v2.mmdb", "GeoLite2-City", 1700000000, {
+    "1.1.1.0/24": city("JP", "Tokyo"),
+    "8.8.8.0/24": city("US", "Mountain View"),
+    "2001:4860::/32": city("US", "Mountain View"),
+})
+build("asn.mmdb", "GeoLite2-ASN", 1600000000, {
+    "1.1.1.0/24": asn(13335, "Cloudflare"),
+    "8.8.8.0/24": asn(15169, "Google"),
+    "2001:4860::/32": asn(15169, "Google"),
+})
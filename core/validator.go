@@ -0,0 +1,454 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/JobberRT/pxier_fetcher/public"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const validatorBodyLimit = 64 << 10
+
+const (
+	purgeDelete  = "delete"
+	purgeArchive = "archive"
+)
+
+// validator checks stored proxies that are due by requesting a target through them, new
+// proxies first. Alive proxies are classified by the judge when there's one. A proxy failing
+// maxErrTimes checks in a row is deleted or archived.
+type validator struct {
+	database    *gorm.DB
+	target      string
+	expectBody  string
+	judgeUrl    string
+	origin      string
+	originUrl   string
+	timeout     time.Duration
+	interval    time.Duration
+	concurrency int
+	batchSize   int
+	recheckMin  time.Duration
+	recheckMax  time.Duration
+	maxErrTimes int
+	purge       string
+	probe       bool
+	probeTarget string
+	filter      *proxyFilter
+}
+
+// archivedProxy is a purged proxy kept in the proxy_archive table.
+type archivedProxy struct {
+	Proxy      `gorm:"embedded"`
+	ArchivedAt int64
+}
+
+func (p *archivedProxy) TableName() string {
+	return "proxy_archive"
+}
+
+type checkResult struct {
+	alive     bool
+	latency   time.Duration
+	anonymity string
+	err       error
+}
+
+// newValidator reads the validator settings, it returns nil when validator.enabled is false.
+func newValidator(db *gorm.DB, filter *proxyFilter) *validator {
+	if !viper.GetBool("validator.enabled") {
+		return nil
+	}
+	v := &validator{
+		database:    db,
+		filter:      filter,
+		target:      viper.GetString("validator.target"),
+		expectBody:  viper.GetString("validator.expect_body"),
+		judgeUrl:    viper.GetString("judge.url"),
+		origin:      viper.GetString("judge.origin_ip"),
+		originUrl:   viper.GetString("judge.origin_url"),
+		timeout:     time.Duration(viper.GetInt64("validator.timeout")) * time.Second,
+		interval:    time.Duration(viper.GetInt64("validator.interval")) * time.Second,
+		concurrency: viper.GetInt("validator.concurrency"),
+		batchSize:   viper.GetInt("validator.batch_size"),
+		recheckMin:  time.Duration(viper.GetInt64("validator.recheck_min")) * time.Second,
+		recheckMax:  time.Duration(viper.GetInt64("validator.recheck_max")) * time.Second,
+		maxErrTimes: 5,
+		purge:       strings.ToLower(viper.GetString("validator.purge")),
+		probe:       true,
+		probeTarget: viper.GetString("validator.probe_target"),
+	}
+	if viper.IsSet("validator.probe") {
+		v.probe = viper.GetBool("validator.probe")
+	}
+	if len(v.probeTarget) == 0 {
+		v.probeTarget = "http://www.gstatic.com/generate_204"
+	}
+	if viper.IsSet("validator.max_err_times") {
+		v.maxErrTimes = viper.GetInt("validator.max_err_times")
+	}
+	if len(v.target) == 0 {
+		v.target = "https://www.gstatic.com/generate_204"
+	}
+	if v.timeout <= 0 {
+		v.timeout = 10 * time.Second
+	}
+	if v.interval <= 0 {
+		v.interval = time.Minute
+	}
+	if v.concurrency <= 0 {
+		v.concurrency = 50
+	}
+	if v.batchSize <= 0 {
+		v.batchSize = 500
+	}
+	if v.recheckMin <= 0 {
+		v.recheckMin = 5 * time.Minute
+	}
+	if v.recheckMax < v.recheckMin {
+		v.recheckMax = 6 * time.Hour
+	}
+	switch v.purge {
+	case "":
+		v.purge = purgeDelete
+	case purgeDelete:
+	case purgeArchive:
+		if err := db.AutoMigrate(&archivedProxy{}); err != nil {
+			logrus.WithError(err).Panic("failed to migrate archive model")
+		}
+	default:
+		logrus.WithField("purge", v.purge).Panic("unknown validator purge mode")
+	}
+	if listen := viper.GetString("judge.listen"); len(v.judgeUrl) == 0 && len(listen) != 0 {
+		logrus.WithField("listen", listen).Panic("judge.url is required when the judge is hosted, set it to the public address of the judge")
+	}
+	if len(v.judgeUrl) != 0 {
+		if err := publicURL(v.judgeUrl); err != nil {
+			logrus.WithError(err).WithField("url", v.judgeUrl).Panic("bad judge.url, proxies have to reach it")
+		}
+	}
+	if len(v.origin) != 0 {
+		if _, err := publicIP(v.origin); err != nil {
+			logrus.WithError(err).WithField("origin_ip", v.origin).Panic("bad judge.origin_ip")
+		}
+	}
+	if len(v.originUrl) == 0 {
+		v.originUrl = "https://api.ipify.org"
+	}
+	return v
+}
+
+// publicURL checks that raw is an http or https url whose host isn't a local or private address.
+func publicURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	if len(host) == 0 || strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("no public host in %s", raw)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		_, err = publicIP(host)
+		return err
+	}
+	return nil
+}
+
+// publicIP parses raw and rejects addresses normalizeProxy would reject.
+func publicIP(raw string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(raw))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr = addr.Unmap()
+	if reason := rejectAddr(addr); len(reason) != 0 {
+		return netip.Addr{}, fmt.Errorf("%s is a %s address", addr, reason)
+	}
+	return addr, nil
+}
+
+// run checks a batch of proxies every interval until ctx is done.
+func (v *validator) run(ctx context.Context) {
+	logrus.WithFields(logrus.Fields{
+		"target":      v.target,
+		"concurrency": v.concurrency,
+	}).Info("validator started")
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		v.round(ctx)
+		timer.Reset(v.interval)
+	}
+}
+
+func (v *validator) round(ctx context.Context) {
+	proxies := make([]*Proxy, 0, v.batchSize)
+	if err := v.database.WithContext(ctx).
+		Where("next_check_at <= ?", time.Now().Unix()).
+		Order("next_check_at, created_at desc").
+		Limit(v.batchSize).
+		Find(&proxies).Error; err != nil {
+		logrus.WithError(err).Error("failed to load proxies to validate")
+		return
+	}
+	origin := v.origin
+	if len(v.judgeUrl) != 0 && len(origin) == 0 {
+		var err error
+		if origin, err = v.detectOrigin(ctx); err != nil {
+			logrus.WithError(err).WithField("echo", v.originUrl).Error("failed to find our ip, anonymity isn't checked this round")
+		}
+	}
+	start := time.Now()
+	alive, purged := 0, 0
+	mismatches := make(map[string]int)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, v.concurrency)
+	for _, pxy := range proxies {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(pxy *Proxy) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var found []probeResult
+			if v.probe && pxy.CheckedAt == 0 {
+				found = v.probeProtocols(ctx, pxy)
+			}
+			r := v.check(ctx, pxy)
+			if r.alive && len(origin) != 0 {
+				var err error
+				if r.anonymity, err = v.judge(ctx, pxy, origin); err != nil {
+					logrus.WithError(err).WithField("address", pxy.Address).Debug("failed to judge proxy")
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			mu.Lock()
+			if r.alive {
+				alive++
+			}
+			if len(r.anonymity) != 0 && len(pxy.Anonymity) != 0 && r.anonymity != pxy.Anonymity {
+				mismatches[pxy.Provider]++
+			}
+			mu.Unlock()
+			if v.save(pxy, r) {
+				mu.Lock()
+				purged++
+				mu.Unlock()
+			}
+			if len(found) != 0 {
+				v.addProtocols(pxy, found)
+			}
+		}(pxy)
+	}
+	wg.Wait()
+	logrus.WithFields(logrus.Fields{
+		"checked":  len(proxies),
+		"alive":    alive,
+		"purged":   purged,
+		"duration": time.Since(start).String(),
+	}).Info("validation round finished")
+	for provider, n := range mismatches {
+		logrus.WithFields(logrus.Fields{
+			"provider":   provider,
+			"mismatches": n,
+		}).Warn("provider claims a different anonymity than the judge found")
+	}
+}
+
+// check requests the target through pxy, it is alive when the response is 2xx and holds expect_body.
+// Http proxies known to forward plain requests are sent http targets that way, others are tunneled.
+func (v *validator) check(ctx context.Context, pxy *Proxy) checkResult {
+	start := time.Now()
+	body, err := v.get(ctx, proxyTransport(pxy, pxy.Forward), v.target)
+	latency := time.Since(start)
+	if err != nil {
+		return checkResult{err: err}
+	}
+	if len(v.expectBody) != 0 && !strings.Contains(string(body), v.expectBody) {
+		return checkResult{err: fmt.Errorf("target response doesn't contain %q", v.expectBody)}
+	}
+	return checkResult{alive: true, latency: latency}
+}
+
+// judge asks the judge what it received through pxy. Http and https proxies forward the
+// request instead of tunneling it, so they get the chance to add their headers.
+func (v *validator) judge(ctx context.Context, pxy *Proxy, origin string) (string, error) {
+	body, err := v.get(ctx, proxyTransport(pxy, true), v.judgeUrl)
+	if err != nil {
+		return "", err
+	}
+	report := &judgeReport{}
+	if err := json.Unmarshal(body, report); err != nil {
+		return "", fmt.Errorf("bad judge report: %w", err)
+	}
+	return classifyAnonymity(origin, report), nil
+}
+
+// detectOrigin asks the echo service at originUrl for our public ip. It may answer with the
+// bare ip or json like {"ip": "1.2.3.4"} or {"origin": "1.2.3.4"}.
+func (v *validator) detectOrigin(ctx context.Context) (string, error) {
+	body, err := v.get(ctx, &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, v.originUrl)
+	if err != nil {
+		return "", err
+	}
+	raw := strings.TrimSpace(string(body))
+	if strings.HasPrefix(raw, "{") {
+		echo := &struct {
+			Ip     string `json:"ip"`
+			Origin string `json:"origin"`
+		}{}
+		if err := json.Unmarshal(body, echo); err != nil {
+			return "", fmt.Errorf("bad echo response: %w", err)
+		}
+		raw = echo.Ip
+		if len(raw) == 0 {
+			raw = echo.Origin
+		}
+	}
+	addr, err := publicIP(raw)
+	if err != nil {
+		return "", fmt.Errorf("echo service didn't report a public ip: %w", err)
+	}
+	return addr.String(), nil
+}
+
+// get requests target within the validator timeout and returns the body of a 2xx response.
+func (v *validator) get(ctx context.Context, transport *http.Transport, target string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+	defer transport.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, validatorBodyLimit))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s responded %s", target, res.Status)
+	}
+	return body, nil
+}
+
+// proxyTransport sends requests through pxy. Unless forward is set every request is tunneled,
+// with CONNECT for http and https proxies.
+func proxyTransport(pxy *Proxy, forward bool) *http.Transport {
+	t := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	if forward && (pxy.DialType == public.DialTypeHttp || pxy.DialType == public.DialTypeHttps) {
+		u, err := url.Parse(pxy.URL())
+		if err == nil {
+			t.Proxy = http.ProxyURL(u)
+			return t
+		}
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialThrough(ctx, pxy, addr)
+	}
+	return t
+}
+
+// save records r and schedules the next check, it reports whether pxy has been purged.
+func (v *validator) save(pxy *Proxy, r checkResult) bool {
+	errTimes := 0
+	if r.err != nil {
+		errTimes = pxy.ErrTimes + 1
+		logrus.WithError(r.err).WithFields(logrus.Fields{
+			"address":   pxy.Address,
+			"dial_type": pxy.DialType,
+			"err_times": errTimes,
+		}).Debug("proxy is dead")
+	}
+	if v.maxErrTimes > 0 && errTimes >= v.maxErrTimes {
+		if err := v.purgeProxy(pxy); err != nil {
+			logrus.WithError(err).WithField("address", pxy.Address).Error("failed to purge proxy")
+			return false
+		}
+		return true
+	}
+
+	now := time.Now()
+	fields := map[string]interface{}{
+		"alive":         r.alive,
+		"latency":       r.latency.Milliseconds(),
+		"checked_at":    now.Unix(),
+		"err_times":     errTimes,
+		"next_check_at": now.Add(v.recheckAfter(pxy, errTimes, now)).Unix(),
+	}
+	if len(r.anonymity) != 0 {
+		fields["detected_anonymity"] = r.anonymity
+	}
+	if err := v.database.Model(&Proxy{}).Where("id = ?", pxy.Id).Updates(fields).Error; err != nil {
+		logrus.WithError(err).WithField("address", pxy.Address).Error("failed to save validation result")
+		return false
+	}
+	pxy.Alive, pxy.Latency, pxy.ErrTimes = r.alive, r.latency.Milliseconds(), errTimes
+	pxy.CheckedAt, pxy.NextCheckAt = fields["checked_at"].(int64), fields["next_check_at"].(int64)
+	return false
+}
+
+// recheckAfter backs off failing proxies, doubling from recheckMin after each failure, while
+// alive ones are rechecked less often as they get older, adding a tenth of their age.
+func (v *validator) recheckAfter(pxy *Proxy, errTimes int, now time.Time) time.Duration {
+	var d time.Duration
+	if errTimes > 0 {
+		d = v.recheckMin << uint(errTimes-1)
+	} else {
+		d = v.recheckMin + now.Sub(time.Unix(pxy.CreatedAt, 0))/10
+	}
+	if d <= 0 || d > v.recheckMax {
+		d = v.recheckMax
+	}
+	return d
+}
+
+func (v *validator) purgeProxy(pxy *Proxy) error {
+	return v.database.Transaction(func(tx *gorm.DB) error {
+		if v.purge == purgeArchive {
+			archived := &archivedProxy{Proxy: *pxy, ArchivedAt: time.Now().Unix()}
+			archived.Id = 0
+			archived.ErrTimes++
+			if err := tx.Create(archived).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&Proxy{}, pxy.Id).Error
+	})
+}
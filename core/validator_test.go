@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JobberRT/pxier_fetcher/public"
+)
+
+func newTestTarget(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			io.WriteString(w, "proxy ok")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDialThrough(t *testing.T) {
+	target := newTestTarget(t)
+	targetAddr := strings.TrimPrefix(target.URL, "http://")
+	connect := startTestProxy(t, serveConnect(false))
+	socks := startTestProxy(t, serveSocks5("user", "pass"))
+	socks4 := startTestProxy(t, serveSocks4("user"))
+
+	cases := []struct {
+		name string
+		pxy  *Proxy
+		ok   bool
+	}{
+		{"http connect", &Proxy{Address: connect.addr(), DialType: public.DialTypeHttp}, true},
+		{"socks5 with auth", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks5, Username: "user", Password: "pass"}, true},
+		{"socks5h with auth", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks5h, Username: "user", Password: "pass"}, true},
+		{"socks5 bad password", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks5, Username: "user", Password: "nope"}, false},
+		{"socks5 without auth", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks5}, false},
+		{"socks5 to http proxy", &Proxy{Address: connect.addr(), DialType: public.DialTypeSocks5}, false},
+		{"socks4", &Proxy{Address: socks4.addr(), DialType: public.DialTypeSocks4, Username: "user"}, true},
+		{"socks4 bad user id", &Proxy{Address: socks4.addr(), DialType: public.DialTypeSocks4, Username: "nope"}, false},
+		{"socks4a host name", &Proxy{Address: socks4.addr(), DialType: public.DialTypeSocks4a, Username: "user"}, true},
+		{"socks4 to socks5 proxy", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks4}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			addr := targetAddr
+			if c.pxy.DialType == public.DialTypeSocks4a {
+				addr = strings.Replace(addr, "127.0.0.1", "localhost", 1)
+			}
+			conn, err := dialThrough(ctx, c.pxy, addr)
+			if !c.ok {
+				if err == nil {
+					conn.Close()
+					t.Fatal("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+targetAddr+"\r\nConnection: close\r\n\r\n")
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != http.StatusOK || string(body) != "proxy ok" {
+				t.Fatalf("got %s %q through the tunnel", res.Status, body)
+			}
+		})
+	}
+}
+
+func TestValidatorCheck(t *testing.T) {
+	target := newTestTarget(t)
+	connect := startTestProxy(t, serveConnect(false))
+	socks := startTestProxy(t, serveSocks5("", ""))
+	silent := startTestProxy(t, serveSilent)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	cases := []struct {
+		name       string
+		pxy        *Proxy
+		path       string
+		expectBody string
+		alive      bool
+	}{
+		{"alive through connect", &Proxy{Address: connect.addr(), DialType: public.DialTypeHttp}, "/", "proxy ok", true},
+		{"alive through socks5", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks5}, "/", "", true},
+		{"dead proxy", &Proxy{Address: closedAddr, DialType: public.DialTypeHttp}, "/", "", false},
+		{"target error", &Proxy{Address: connect.addr(), DialType: public.DialTypeHttp}, "/fail", "", false},
+		{"unexpected body", &Proxy{Address: socks.addr(), DialType: public.DialTypeSocks5}, "/", "something else", false},
+		{"wrong protocol", &Proxy{Address: socks.addr(), DialType: public.DialTypeHttp}, "/", "", false},
+		{"timeout", &Proxy{Address: silent.addr(), DialType: public.DialTypeSocks5}, "/", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := &validator{target: target.URL + c.path, expectBody: c.expectBody, timeout: 500 * time.Millisecond}
+			start := time.Now()
+			r := v.check(context.Background(), c.pxy)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("check took %s, longer than the timeout", elapsed)
+			}
+			if r.alive != c.alive {
+				t.Fatalf("got alive %v with %v, want %v", r.alive, r.err, c.alive)
+			}
+			if c.alive && (r.err != nil || r.latency <= 0) {
+				t.Fatalf("alive proxy has error %v and latency %s", r.err, r.latency)
+			}
+			if !c.alive && r.err == nil {
+				t.Fatal("dead proxy has no error")
+			}
+		})
+	}
+}
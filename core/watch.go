@@ -0,0 +1,118 @@
+package core
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileWatcher calls onChange a moment after any of its files changed, so a file being
+// copied in place is read once it's complete.
+type fileWatcher struct {
+	name     string
+	watcher  *fsnotify.Watcher
+	timer    *time.Timer
+	onChange func()
+	closed   bool
+	mu       sync.Mutex
+	// running is held while onChange runs, close waits for it
+	running sync.Mutex
+}
+
+// watchFiles watches the dirs of paths, which may be glob patterns like /data/*.txt. It returns
+// nil when the watcher can't be created.
+func watchFiles(name string, paths []string, onChange func()) *fileWatcher {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.WithError(err).WithField("watcher", name).Error("failed to create watcher, files won't be reloaded on change")
+		return nil
+	}
+	w := &fileWatcher{name: name, watcher: watcher, onChange: onChange}
+	patterns := make([]string, 0, len(paths))
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		if len(path) == 0 {
+			continue
+		}
+		patterns = append(patterns, filepath.Clean(path))
+		if dirs[filepath.Dir(path)] {
+			continue
+		}
+		dirs[filepath.Dir(path)] = true
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			logrus.WithError(err).WithField("dir", filepath.Dir(path)).Error("failed to watch dir")
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod || !matchAny(patterns, filepath.Clean(event.Name)) {
+					continue
+				}
+				logrus.WithFields(logrus.Fields{
+					"watcher": name,
+					"file":    event.Name,
+				}).Info("watched file changed")
+				w.schedule()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.WithError(err).WithField("watcher", name).Error("watcher error")
+			}
+		}
+	}()
+	return w
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fileWatcher) schedule() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(time.Second, w.fire)
+}
+
+func (w *fileWatcher) fire() {
+	w.running.Lock()
+	defer w.running.Unlock()
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if !closed {
+		w.onChange()
+	}
+}
+
+// close stops watching, once it returns onChange isn't running and won't be called again.
+func (w *fileWatcher) close() {
+	w.watcher.Close()
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	w.running.Lock()
+	w.running.Unlock()
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/JobberRT/pxier_fetcher/core"
 	nFormatter "github.com/antonfisher/nested-logrus-formatter"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -51,8 +54,18 @@ func main() {
 		logrus.Panic("missing factory's selected_executor param")
 	}
 	for _, s := range selected {
-		e := core.NewExecutor(strings.ToUpper(s))
+		e, err := core.NewExecutor(s)
+		if err != nil {
+			logrus.WithError(err).WithField("registered", core.ExecutorTypes()).Panic("failed to create executor")
+		}
 		f.RegisterExecutor(e)
 	}
-	f.Start()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := f.Run(ctx); err != nil {
+		logrus.WithError(err).Error("factory stopped with error")
+		return
+	}
+	logrus.Info("factory stopped")
 }
@@ -1,13 +1,68 @@
 package public
 
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ExecutorTypeIHuan   = "IHUAN"
+	ExecutorTypeCPL     = "CPL"
+	ExecutorTypeTSX     = "TSX"
+	ExecutorTypeSTR     = "STR"
+	ExecutorTypeList    = "LIST"
+	ExecutorTypeTable   = "TABLE"
+	ExecutorTypeJSON    = "JSON"
+	ExecutorTypeCommand = "COMMAND"
+	ExecutorTypeFile    = "FILE"
+)
+
+type DialType string
+
+const (
+	DialTypeHttp    DialType = "http"
+	DialTypeHttps   DialType = "https"
+	DialTypeSocks4  DialType = "socks4"
+	DialTypeSocks4a DialType = "socks4a"
+	DialTypeSocks5  DialType = "socks5"
+	DialTypeSocks5h DialType = "socks5h"
+)
+
+var DialTypes = []DialType{
+	DialTypeHttp,
+	DialTypeHttps,
+	DialTypeSocks4,
+	DialTypeSocks4a,
+	DialTypeSocks5,
+	DialTypeSocks5h,
+}
+
+func (t DialType) Valid() bool {
+	for _, each := range DialTypes {
+		if t == each {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseDialType(s string) (DialType, error) {
+	t := DialType(strings.ToLower(strings.TrimSpace(s)))
+	if !t.Valid() {
+		return "", fmt.Errorf("unknown dial type %q", s)
+	}
+	return t, nil
+}
+
 const (
-	ExecutorTypeIHuan = "IHUAN"
-	ExecutorTypeCPL   = "CPL"
-	ExecutorTypeTSX   = "TSX"
-	ExecutorTypeSTR   = "STR"
+	AnonymityTransparent = "transparent"
+	AnonymityAnonymous   = "anonymous"
+	AnonymityElite       = "elite"
 )
 
 const (
-	DialTypeHttp   = "http"
-	DialTypeSocks5 = "socks5"
+	NetworkTypeDatacenter  = "datacenter"
+	NetworkTypeResidential = "residential"
+	NetworkTypeMobile      = "mobile"
+	NetworkTypeUnknown     = "unknown"
 )